@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// createAdmin makes the user with the given email an admin, registering them
+// first with a verified email address when they don't exist yet. The password
+// of new users is read from ADMIN_PASSWORD to keep it out of the shell
+// history.
+//
+//	blogapi create-admin -email admin@example.com [-name Admin]
+func createAdmin(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin")
+	name := flags.String("name", "Admin", "name of the admin, if they are registered")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("provide the email of the admin with -email")
+	}
+
+	var user model.User
+	tx := db.First(&user, "email = ?", *email)
+	if tx.Error == nil {
+		tx = db.Model(&user).Update("role", model.RoleAdmin)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		fmt.Printf("%s is now an admin\n", *email)
+		return nil
+	}
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return tx.Error
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	r := model.RegisterRequest{Email: *email, Password: password, PasswordConfirm: password}
+	if err := r.Validate(); err != nil {
+		return fmt.Errorf("set ADMIN_PASSWORD to register %s: %w", *email, err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user = model.User{
+		Name:       *name,
+		Email:      *email,
+		Password:   string(hash),
+		CreatedAt:  now,
+		Role:       model.RoleAdmin,
+		VerifiedAt: &now,
+	}
+	tx = db.Create(&user)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	fmt.Printf("registered %s as an admin\n", *email)
+	return nil
+}
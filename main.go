@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo"
@@ -24,26 +30,107 @@ func main() {
 
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Europe/Istanbul", os.Getenv("POSTGRES_HOST"), os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DB"), os.Getenv("POSTGRES_PORT"))
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if limit := os.Getenv("POST_CONTENT_MAX_LENGTH"); limit != "" {
+		model.MaxContentLength, err = strconv.Atoi(limit)
+		if err != nil || model.MaxContentLength < 1 {
+			log.Fatalf("invalid POST_CONTENT_MAX_LENGTH: %q", limit)
+		}
+	}
+
+	// TranslateError turns unique violations into gorm.ErrDuplicatedKey
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		panic(err)
 	}
 
-	db.AutoMigrate(&model.User{}, &model.Post{})
+	userStore := repository.NewUserRepository(db)
+	if err := userStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate users: %s", err)
+	}
+
+	db.AutoMigrate(&model.User{}, &model.Post{}, &model.Session{}, &model.Comment{}, &model.Tag{}, &model.PostRevision{}, &model.PostSlug{}, &model.Media{}, &model.MediaVariant{}, &model.PasswordReset{}, &model.RecoveryCode{}, &model.LoginChallenge{}, &model.Identity{}, &model.OIDCState{}, &model.LoginAttempt{}, &model.RateLimitBucket{}, &model.APIKey{})
+
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		if err := createAdmin(db, os.Args[2:]); err != nil {
+			log.Fatalf("failed to create admin: %s", err)
+		}
+		return
+	}
 
 	authStore := repository.NewAuthRepository(db)
 	postStore := repository.NewPostRepository(db)
-	userStore := repository.NewUserRepository(db)
-	srv := server.NewServer(authStore, postStore, userStore)
+	if err := postStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate post slugs: %s", err)
+	}
+	sessionStore := repository.NewSessionRepository(db)
+	commentStore := repository.NewCommentRepository(db)
+	tagStore := repository.NewTagRepository(db)
+	revisionStore := repository.NewRevisionRepository(db)
+	if err := revisionStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate post revisions: %s", err)
+	}
+	searchStore := repository.NewSearchRepository(db)
+	if err := searchStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate search index: %s", err)
+	}
+	mediaStore := repository.NewMediaRepository(db)
+	blobStore, err := newBlobStore()
+	if err != nil {
+		log.Fatalf("failed to set up media storage: %s", err)
+	}
+	mailer, err := newMailer()
+	if err != nil {
+		log.Fatalf("failed to set up the mailer: %s", err)
+	}
+	loginAttempts, err := newLoginAttemptStore(db)
+	if err != nil {
+		log.Fatalf("failed to set up login attempt counters: %s", err)
+	}
+	rateLimitStore, err := newRateLimitStore(db)
+	if err != nil {
+		log.Fatalf("failed to set up rate limiting: %s", err)
+	}
+	// links in emails and the redirect URI of identity providers point here
+	if os.Getenv("APP_URL") == "" {
+		log.Fatal("APP_URL is not specified in the .env file")
+	}
+	keys, err := newKeySet()
+	if err != nil {
+		log.Fatalf("failed to set up token signing keys: %s", err)
+	}
+	srv := server.NewServer(authStore, postStore, userStore, sessionStore, commentStore, tagStore, searchStore, revisionStore, mediaStore, blobStore, mailer, loginAttempts, rateLimitStore, keys)
+	rateLimits, err := rateLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("failed to set up rate limiting: %s", err)
+	}
+	for group, limit := range rateLimits {
+		srv.SetRateLimit(group, limit)
+	}
+	providers, err := oidcProvidersFromEnv()
+	if err != nil {
+		log.Fatalf("failed to set up identity providers: %s", err)
+	}
+	for _, provider := range providers {
+		srv.AddOIDCProvider(provider)
+	}
 	g := srv.E.Group("/v1")
 
 	g.GET("", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Blog API")
 	})
 
+	srv.StartScheduler(context.Background(), time.Minute)
+	srv.StartMediaWorkers(context.Background(), runtime.NumCPU())
+
 	srv.RegisterAuthRoutes(g)
 	srv.RegisterPostRoutes(g)
+	srv.RegisterCommentRoutes(g)
+	srv.RegisterRevisionRoutes(g)
+	srv.RegisterMediaRoutes(g)
+	srv.RegisterTagRoutes(g)
 	srv.RegisterUserRoutes(g)
+	srv.RegisterAdminRoutes(g)
+	srv.RegisterWellKnownRoutes(srv.E.Group("/.well-known"))
 
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
@@ -54,3 +141,206 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// newLoginAttemptStore counts failed logins in PostgreSQL, or in memory when
+// LOGIN_ATTEMPT_STORE is memory and there is a single instance of the API.
+func newLoginAttemptStore(db *gorm.DB) (repository.LoginAttemptStore, error) {
+	switch os.Getenv("LOGIN_ATTEMPT_STORE") {
+	case "", "postgres":
+		return repository.NewLoginAttemptRepository(db), nil
+	case "memory":
+		return repository.NewMemoryLoginAttemptRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown LOGIN_ATTEMPT_STORE %q", os.Getenv("LOGIN_ATTEMPT_STORE"))
+	}
+}
+
+// newKeySet signs tokens with the PEM encoded private key in the file
+// JWT_SIGNING_KEY, accepting tokens signed with the keys in the files listed
+// in JWT_VERIFY_KEYS too, or with JWT_SECRET and HS256 when there is no
+// signing key. JWT_ISSUER and JWT_AUDIENCE default to blog-api.
+func newKeySet() (*server.KeySet, error) {
+	var signing *server.SigningKey
+	var verifying []*server.SigningKey
+	if path := os.Getenv("JWT_SIGNING_KEY"); path != "" {
+		key, err := readSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		signing = key
+	}
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		key, err := readSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		verifying = append(verifying, key)
+	}
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		if signing == nil {
+			signing = server.NewHMACKey([]byte(secret))
+		} else {
+			verifying = append(verifying, server.NewHMACKey([]byte(secret)))
+		}
+	}
+	if signing == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY or JWT_SECRET is not specified")
+	}
+
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "blog-api"
+	}
+	audience := os.Getenv("JWT_AUDIENCE")
+	if audience == "" {
+		audience = "blog-api"
+	}
+	return server.NewKeySet(issuer, audience, signing, verifying...)
+}
+
+func readSigningKey(path string) (*server.SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := server.ParseSigningKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// newRateLimitStore keeps rate limit buckets in memory, or in PostgreSQL when
+// RATE_LIMIT_STORE is postgres so several instances of the API share them.
+func newRateLimitStore(db *gorm.DB) (repository.RateLimitStore, error) {
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		return repository.NewMemoryRateLimitRepository(), nil
+	case "postgres":
+		return repository.NewRateLimitRepository(db), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", os.Getenv("RATE_LIMIT_STORE"))
+	}
+}
+
+// rateLimitsFromEnv reads the policies of the route groups set in
+// RATE_LIMIT_AUTH, RATE_LIMIT_POSTS, RATE_LIMIT_USER and RATE_LIMIT_DEFAULT,
+// as requests per period like "20/1m", or "off".
+func rateLimitsFromEnv() (map[string]repository.RateLimit, error) {
+	limits := make(map[string]repository.RateLimit)
+	for _, group := range []string{"auth", "posts", "user", "default"} {
+		name := "RATE_LIMIT_" + strings.ToUpper(group)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if value == "off" {
+			limits[group] = repository.RateLimit{}
+			continue
+		}
+
+		requests, period, _ := strings.Cut(value, "/")
+		n, err := strconv.Atoi(requests)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid %s: %q", name, value)
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s: %q", name, value)
+		}
+		limits[group] = repository.RateLimit{Requests: n, Period: d}
+	}
+	return limits, nil
+}
+
+// newBlobStore stores media in the directory MEDIA_DIR, or in an S3 bucket
+// when MEDIA_STORAGE is s3.
+func newBlobStore() (repository.BlobStore, error) {
+	switch os.Getenv("MEDIA_STORAGE") {
+	case "", "local":
+		dir := os.Getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return repository.NewLocalBlobRepository(dir)
+	case "s3":
+		return repository.NewS3BlobRepository(repository.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORAGE %q", os.Getenv("MEDIA_STORAGE"))
+	}
+}
+
+// oidcProvidersFromEnv reads the identity providers listed in OIDC_PROVIDERS,
+// e.g. "google,gitlab", each set up with OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET and optionally _SCOPES.
+func oidcProvidersFromEnv() ([]server.OIDCConfig, error) {
+	var providers []server.OIDCConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !oidcProviderName.MatchString(name) {
+			return nil, fmt.Errorf("invalid provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		config := server.OIDCConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("set %sISSUER and %sCLIENT_ID", prefix, prefix)
+		}
+		providers = append(providers, config)
+	}
+	return providers, nil
+}
+
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// newMailer sends emails through the SMTP server set with the SMTP_*
+// settings when MAILER is smtp. Otherwise they are written to the file
+// MAIL_LOG, or to stdout.
+func newMailer() (repository.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "blog-api@localhost"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		path := os.Getenv("MAIL_LOG")
+		if path == "" {
+			return repository.NewLogMailer(from, os.Stdout), nil
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return repository.NewLogMailer(from, f), nil
+	case "smtp":
+		return repository.NewSMTPMailer(repository.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+	}
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// API keys are limited to the scopes they were created with. Routes that
+// don't take a scope, like changing the password, can't be used with them.
+const (
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+	ScopeProfile    = "profile"
+)
+
+// APIKey lets scripts act for a user without a session. Only the hash of the
+// key is stored, its first characters are kept to tell keys apart.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"type:varchar(64);not null"`
+	Prefix     string `gorm:"type:varchar(16);not null"`
+	KeyHash    string `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Scopes     string `gorm:"type:varchar(255);not null"` // separated by spaces
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time `gorm:"default:current_timestamp"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Fields(k.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the key has stopped working. Keys without an
+// expiry work until they are revoked.
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !k.ExpiresAt.After(now)
+}
+
+type CreateAPIKeyRequest struct {
+	Name            string     `json:"name"`
+	Scopes          []string   `json:"scopes"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	CurrentPassword string     `json:"current_password"`
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreatedAPIKeyResponse holds the key itself, which is only shown once.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func (r CreateAPIKeyRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Name, validation.Required, validation.Length(1, 64)),
+		validation.Field(&r.Scopes, validation.Required, validation.Each(validation.In(ScopePostsRead, ScopePostsWrite, ScopeProfile))),
+		validation.Field(&r.ExpiresAt, validation.By(validateExpiresAt)),
+		validation.Field(&r.CurrentPassword, validation.Required),
+	)
+}
+
+func validateExpiresAt(value interface{}) error {
+	expiresAt, _ := value.(*time.Time)
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return errors.New("must be in the future")
+	}
+	return nil
+}
+
+// NewAPIKeyResponse leaves out the hash of the key.
+func NewAPIKeyResponse(k *APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     strings.Fields(k.Scopes),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+type Comment struct {
+	ID        uint       `gorm:"primaryKey" json:"id,omitempty"`
+	PostID    uint       `gorm:"not null;index" json:"postid,omitempty"`
+	UserID    uint       `gorm:"not null;index" json:"userid,omitempty"`
+	ParentID  *uint      `gorm:"index" json:"parentid,omitempty"`
+	Content   string     `gorm:"not null" json:"content,omitempty"`
+	CreatedAt time.Time  `gorm:"not null" json:"created_at,omitempty"`
+	UpdatedAt time.Time  `gorm:"not null" json:"updated_at,omitempty"`
+	Replies   []*Comment `gorm:"-" json:"replies,omitempty"`
+}
+
+func (c Comment) OwnerID() uint {
+	return c.UserID
+}
+
+type CreateCommentRequest struct {
+	Content  string `json:"content" binding:"required"`
+	ParentID *uint  `json:"parentid,omitempty"`
+}
+
+type UpdateCommentRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+func (r CreateCommentRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Content, validation.Required, validation.Length(1, 1000)),
+	)
+}
+
+func (r UpdateCommentRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Content, validation.Required, validation.Length(1, 1000)),
+	)
+}
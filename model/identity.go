@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Identity links a user to their account at an external identity provider,
+// which knows them by Subject.
+type Identity struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	Provider  string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_identities_provider_subject,priority:1"`
+	Subject   string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_identities_provider_subject,priority:2"`
+	Email     string    `gorm:"type:varchar(255)"`
+	CreatedAt time.Time `gorm:"default:current_timestamp"`
+}
+
+// OIDCState is a login at an identity provider in progress, kept until the
+// provider redirects back. Only the hash of the state sent to the provider is
+// stored, with the nonce and PKCE verifier checked when the login completes.
+type OIDCState struct {
+	ID        uint      `gorm:"primaryKey"`
+	StateHash string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Provider  string    `gorm:"type:varchar(64);not null"`
+	Nonce     string    `gorm:"type:varchar(64);not null"`
+	Verifier  string    `gorm:"type:varchar(128);not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time `gorm:"default:current_timestamp"`
+}
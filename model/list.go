@@ -0,0 +1,17 @@
+package model
+
+// ListResponse is the envelope of every list endpoint. Page is only set for
+// page/limit pagination and NextCursor for cursor pagination.
+type ListResponse[T any] struct {
+	Data       []T       `json:"data"`
+	Total      int64     `json:"total"`
+	Page       int       `json:"page,omitempty"`
+	Limit      int       `json:"limit"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Links      ListLinks `json:"links"`
+}
+
+type ListLinks struct {
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
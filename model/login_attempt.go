@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// LoginAttempt counts the failed logins of a key, such as an IP address or an
+// email address, and locks the key out for a while when there are too many.
+type LoginAttempt struct {
+	Key          string    `gorm:"primaryKey;type:varchar(100)"`
+	Failures     int       `gorm:"not null"`
+	LastFailedAt time.Time `gorm:"not null;index"`
+	LockedUntil  time.Time `gorm:"not null"`
+}
+
+// IsLocked reports whether logins of the key are refused at now.
+func (a LoginAttempt) IsLocked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
@@ -0,0 +1,84 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+const maxMediaPerPost = 20
+
+// Uploads are pending until their variants are generated. Uploads that
+// can't be decoded fail.
+const (
+	MediaStatusPending = "pending"
+	MediaStatusReady   = "ready"
+	MediaStatusFailed  = "failed"
+)
+
+// Every image is served in these variants. Only the thumbnail and medium
+// variants are resized, all of them are re-encoded without metadata.
+const (
+	MediaVariantThumbnail = "thumbnail"
+	MediaVariantMedium    = "medium"
+	MediaVariantOriginal  = "original"
+)
+
+// Media is an image uploaded by a user. The upload is kept in a blob store
+// under Key until its variants are generated, posts refer to it by ID.
+type Media struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	UserID      uint            `gorm:"not null;index" json:"userid"`
+	Key         string          `gorm:"type:varchar(255);uniqueIndex;not null" json:"-"`
+	ContentType string          `gorm:"type:varchar(64);not null" json:"content_type"`
+	Size        int64           `gorm:"not null" json:"size"`
+	Width       int             `gorm:"not null" json:"width"`
+	Height      int             `gorm:"not null" json:"height"`
+	CreatedAt   time.Time       `gorm:"not null;index" json:"created_at"`
+	Status      string          `gorm:"type:varchar(16);not null;default:pending;index" json:"status"`
+	Variants    []*MediaVariant `json:"variants,omitempty"`
+}
+
+// MediaVariant is a version of an image made for a particular use, such as
+// thumbnails in feeds.
+type MediaVariant struct {
+	ID          uint   `gorm:"primaryKey" json:"-"`
+	MediaID     uint   `gorm:"not null;uniqueIndex:idx_media_variants_media_id_name,priority:1" json:"-"`
+	Name        string `gorm:"type:varchar(16);not null;uniqueIndex:idx_media_variants_media_id_name,priority:2" json:"name"`
+	Key         string `gorm:"type:varchar(255);not null" json:"-"`
+	ContentType string `gorm:"type:varchar(64);not null" json:"content_type"`
+	Size        int64  `gorm:"not null" json:"size"`
+	Width       int    `gorm:"not null" json:"width"`
+	Height      int    `gorm:"not null" json:"height"`
+}
+
+func (m Media) OwnerID() uint {
+	return m.UserID
+}
+
+// validateMediaIDs checks that a post doesn't refer to too many media.
+func validateMediaIDs(value interface{}) error {
+	ids, _ := value.([]uint)
+	if len(ids) > maxMediaPerPost {
+		return errors.New("a post can have at most 20 media")
+	}
+	return nil
+}
+
+// mediaFromIDs builds the media of a post from request data, dropping
+// duplicates. Like tags, nil means the media of the post are left alone.
+func mediaFromIDs(ids []uint) []*Media {
+	if ids == nil {
+		return nil
+	}
+
+	media := []*Media{}
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		media = append(media, &Media{ID: id})
+	}
+	return media
+}
@@ -1,33 +1,145 @@
 package model
 
 import (
+	"errors"
 	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation"
+	"gorm.io/gorm"
 )
 
+// Only published posts are visible to everyone, the others only to their
+// author. Scheduled posts are published by the scheduler once PublishAt is
+// reached.
+const (
+	PostStatusDraft     = "draft"
+	PostStatusScheduled = "scheduled"
+	PostStatusPublished = "published"
+	PostStatusArchived  = "archived"
+)
+
+// The content of a post is either plain text or Markdown. Both are rendered
+// to sanitized HTML in content_html.
+const (
+	ContentFormatPlain    = "plain"
+	ContentFormatMarkdown = "markdown"
+)
+
+// MaxContentLength is the number of characters the content of a post can
+// have at most. It is set from POST_CONTENT_MAX_LENGTH at startup.
+var MaxContentLength = 20000
+
 type Post struct {
-	ID        uint      `gorm:"primaryKey" json:"id,omitempty"`
-	UserID    uint      `gorm:"not null" json:"userid,omitempty"`
-	Title     string    `gorm:"uniqueIndex;not null" json:"title,omitempty"`
-	Content   string    `gorm:"not null" json:"content,omitempty"`
-	CreatedAt time.Time `gorm:"not null" json:"created_at,omitempty"`
-	UpdatedAt time.Time `gorm:"not null" json:"updated_at,omitempty"`
+	ID            uint       `gorm:"primaryKey;index:idx_posts_created_at_id,priority:2" json:"id,omitempty"`
+	UserID        uint       `gorm:"not null" json:"userid,omitempty"`
+	Title         string     `gorm:"not null" json:"title,omitempty"`
+	Slug          string     `gorm:"type:varchar(255);uniqueIndex" json:"slug,omitempty"`
+	Content       string     `gorm:"not null" json:"content,omitempty"`
+	ContentFormat string     `gorm:"type:varchar(16);not null;default:plain" json:"content_format,omitempty"`
+	ContentHTML   string     `gorm:"-" json:"content_html,omitempty"`
+	CreatedAt     time.Time  `gorm:"not null;index:idx_posts_created_at_id,priority:1" json:"created_at,omitempty"`
+	UpdatedAt     time.Time  `gorm:"not null" json:"updated_at,omitempty"`
+	Tags          []*Tag     `gorm:"many2many:post_tags" json:"tags,omitempty"`
+	Media         []*Media   `gorm:"many2many:post_media" json:"media,omitempty"`
+	Status        string     `gorm:"type:varchar(16);not null;default:published;index" json:"status,omitempty"`
+	PublishAt     *time.Time `json:"publish_at,omitempty"`
+	Revision      int        `gorm:"not null;default:1" json:"revision,omitempty"`
+	// DeletedAt keeps deleted posts restorable until they are purged
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (p Post) OwnerID() uint {
+	return p.UserID
+}
+
+func (p Post) IsPublished() bool {
+	return p.Status == PostStatusPublished
 }
 
 type CreatePostRequest struct {
-	Title   string `json:"title" binding:"required"`
-	Content string `json:"content" binding:"required"`
+	Title         string     `json:"title" binding:"required"`
+	Content       string     `json:"content" binding:"required"`
+	ContentFormat string     `json:"content_format,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	MediaIDs      []uint     `json:"media_ids,omitempty"`
+	Status        string     `json:"status,omitempty"`
+	PublishAt     *time.Time `json:"publish_at,omitempty"`
 }
 
 type UpdatePostRequest struct {
-	Title   string `json:"title,omitempty"`
-	Content string `json:"content,omitempty"`
+	Title         string     `json:"title,omitempty"`
+	Content       string     `json:"content,omitempty"`
+	ContentFormat string     `json:"content_format,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	MediaIDs      []uint     `json:"media_ids,omitempty"`
+	Status        string     `json:"status,omitempty"`
+	PublishAt     *time.Time `json:"publish_at,omitempty"`
 }
 
 func (p CreatePostRequest) Validate() error {
+	if err := validatePublishAt(p.Status, p.PublishAt); err != nil {
+		return err
+	}
+
 	return validation.ValidateStruct(&p,
 		validation.Field(&p.Title, validation.Required, validation.Length(1, 16)),
-		validation.Field(&p.Content, validation.Length(1, 160)),
+		validation.Field(&p.Content, validation.Length(1, MaxContentLength)),
+		validation.Field(&p.ContentFormat, validation.In(ContentFormatPlain, ContentFormatMarkdown)),
+		validation.Field(&p.Tags, validation.By(validateTags)),
+		validation.Field(&p.MediaIDs, validation.By(validateMediaIDs)),
+		validation.Field(&p.Status, validation.In(PostStatusDraft, PostStatusScheduled, PostStatusPublished)),
+	)
+}
+
+func (p CreatePostRequest) PostTags() []*Tag {
+	return tagsFromNames(p.Tags)
+}
+
+func (p CreatePostRequest) PostMedia() []*Media {
+	return mediaFromIDs(p.MediaIDs)
+}
+
+func (p UpdatePostRequest) Validate() error {
+	if err := validatePublishAt(p.Status, p.PublishAt); err != nil {
+		return err
+	}
+
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Content, validation.Length(1, MaxContentLength)),
+		validation.Field(&p.ContentFormat, validation.In(ContentFormatPlain, ContentFormatMarkdown)),
+		validation.Field(&p.Tags, validation.By(validateTags)),
+		validation.Field(&p.MediaIDs, validation.By(validateMediaIDs)),
+		validation.Field(&p.Status, validation.In(PostStatusDraft, PostStatusScheduled, PostStatusPublished, PostStatusArchived)),
 	)
 }
+
+// validatePublishAt checks that publish_at is given in the future when, and
+// only when, a post is scheduled.
+func validatePublishAt(status string, publishAt *time.Time) error {
+	if status != PostStatusScheduled {
+		if publishAt != nil {
+			return errors.New("publish_at can only be set when scheduling a post")
+		}
+		return nil
+	}
+
+	if publishAt == nil {
+		return errors.New("publish_at is required to schedule a post")
+	}
+	if !publishAt.After(time.Now()) {
+		return errors.New("publish_at must be in the future")
+	}
+	return nil
+}
+
+// PostTags returns the tags to set on the post, nil if they weren't part of
+// the request.
+func (p UpdatePostRequest) PostTags() []*Tag {
+	return tagsFromNames(p.Tags)
+}
+
+// PostMedia returns the media to set on the post, nil if they weren't part of
+// the request.
+func (p UpdatePostRequest) PostMedia() []*Media {
+	return mediaFromIDs(p.MediaIDs)
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// RateLimitBucket is the token bucket of a client for a group of routes. Each
+// request takes a token, and tokens are added back over time.
+type RateLimitBucket struct {
+	Key        string    `gorm:"primaryKey;type:varchar(100)"`
+	Tokens     float64   `gorm:"not null"`
+	RefilledAt time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// PostRevision is the title, content and content format of a post as they
+// were after one of its edits. Revisions are numbered from 1 for each post.
+type PostRevision struct {
+	ID            uint      `gorm:"primaryKey" json:"-"`
+	PostID        uint      `gorm:"not null;uniqueIndex:idx_post_revisions_post_id_revision,priority:1" json:"postid"`
+	Revision      int       `gorm:"not null;uniqueIndex:idx_post_revisions_post_id_revision,priority:2" json:"revision"`
+	Title         string    `gorm:"not null" json:"title"`
+	Content       string    `gorm:"not null" json:"content"`
+	ContentFormat string    `gorm:"type:varchar(16);not null;default:plain" json:"content_format"`
+	CreatedAt     time.Time `gorm:"not null" json:"created_at"`
+}
+
+type RevisionDiff struct {
+	From int    `json:"from"`
+	To   int    `json:"to"`
+	Diff string `json:"diff"`
+}
@@ -0,0 +1,46 @@
+package model
+
+// Every user has one of these roles. Editors moderate content, admins also
+// manage users.
+const (
+	RoleUser   = "user"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+type Permission string
+
+const (
+	// PermissionEditAnyPost allows editing, deleting and restoring posts of
+	// other users, along with the comments on them.
+	PermissionEditAnyPost Permission = "posts:edit-any"
+	// PermissionUnpublishPost allows turning any published post back into a
+	// draft.
+	PermissionUnpublishPost Permission = "posts:unpublish"
+	// PermissionManageUsers allows listing, suspending and deleting users and
+	// changing their roles.
+	PermissionManageUsers Permission = "users:manage"
+)
+
+// rolePermissions is the permission matrix. Users only have the permissions
+// every user has on their own content.
+var rolePermissions = map[string][]Permission{
+	RoleUser:   nil,
+	RoleEditor: {PermissionEditAnyPost, PermissionUnpublishPost},
+	RoleAdmin:  {PermissionEditAnyPost, PermissionUnpublishPost, PermissionManageUsers},
+}
+
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleCan reports whether users with the role have the permission.
+func RoleCan(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
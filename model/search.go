@@ -0,0 +1,9 @@
+package model
+
+// SearchResult is a post matching a search query, with its relevance and an
+// excerpt of the content where the matching words are wrapped in <mark> tags.
+type SearchResult struct {
+	Post
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
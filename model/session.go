@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Session is a single refresh token. Every rotation creates a new row in the
+// same family, so the family ID identifies the login session as a whole.
+type Session struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	FamilyID  string    `gorm:"type:varchar(64);not null;index"`
+	TokenHash string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time `gorm:"default:current_timestamp"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
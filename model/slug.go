@@ -0,0 +1,53 @@
+package model
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxSlugLength = 80
+
+// PostSlug is a slug a post used before its title changed, kept so that old
+// links redirect to the current slug.
+type PostSlug struct {
+	ID        uint      `gorm:"primaryKey"`
+	PostID    uint      `gorm:"not null;index"`
+	Slug      string    `gorm:"type:varchar(255);uniqueIndex;not null"`
+	CreatedAt time.Time `gorm:"default:current_timestamp"`
+}
+
+// Slugify turns a title into a lowercase slug made of the letters and digits
+// of the title separated by dashes. Letters of any script are kept, only
+// their accents are dropped, so "Çay ve Kahve" becomes "cay-ve-kahve" and
+// "東京の夏" stays "東京の夏".
+func Slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(strings.ToLower(title)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		default:
+			dash = true
+		}
+	}
+
+	slug := []rune(norm.NFC.String(b.String()))
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+	}
+
+	if s := strings.TrimRight(string(slug), "-"); s != "" {
+		return s
+	}
+	return "post"
+}
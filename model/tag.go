@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const maxTagsPerPost = 10
+
+type Tag struct {
+	ID   uint   `gorm:"primaryKey" json:"-"`
+	Name string `gorm:"type:varchar(32);uniqueIndex;not null" json:"name"`
+}
+
+// TagCount is a tag along with the number of posts using it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// NormalizeTag lowercases a tag and trims the spaces around it, so "Go" and
+// " go" end up as the same tag.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// validateTags checks that a list of tag names is small enough and that every
+// tag is made of letters, digits and dashes.
+func validateTags(value interface{}) error {
+	names, _ := value.([]string)
+	if len(names) > maxTagsPerPost {
+		return errors.New("a post can have at most 10 tags")
+	}
+
+	for _, name := range names {
+		name = NormalizeTag(name)
+		if name == "" || len(name) > 32 {
+			return errors.New("tags must be between 1 and 32 characters")
+		}
+		for _, r := range name {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' {
+				return errors.New("tags may only contain letters, digits and dashes")
+			}
+		}
+	}
+	return nil
+}
+
+// tagsFromNames builds the tags of a post from request data, dropping
+// duplicates. A nil slice is kept nil so updates can tell "leave tags alone"
+// apart from "remove all tags".
+func tagsFromNames(names []string) []*Tag {
+	if names == nil {
+		return nil
+	}
+
+	tags := []*Tag{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = NormalizeTag(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		tags = append(tags, &Tag{Name: name})
+	}
+	return tags
+}
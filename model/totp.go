@@ -0,0 +1,84 @@
+package model
+
+import (
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// RecoveryCode lets a user with two-factor authentication log in without
+// their authenticator app. Only its hash is stored, and it can be used once.
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"type:varchar(64);not null"`
+	UsedAt   *time.Time
+}
+
+// LoginChallenge is the second step of logging in with two-factor
+// authentication, started once the password is checked. Only the hash of its
+// token is stored, and it allows a few attempts at the code.
+type LoginChallenge struct {
+	ID          uint      `gorm:"primaryKey"`
+	UserID      uint      `gorm:"not null;index"`
+	TokenHash   string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ReturnToken bool      `gorm:"not null;default:false"`
+	Attempts    int       `gorm:"not null;default:0"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	UsedAt      *time.Time
+	CreatedAt   time.Time `gorm:"default:current_timestamp"`
+}
+
+// TwoFactorChallengeResponse is returned by login instead of a session when
+// the user has two-factor authentication on.
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// TwoFactorSetupResponse holds the secret to add to an authenticator app,
+// also as an otpauth:// URI to show as a QR code.
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorSetupRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// TwoFactorCodeRequest carries a code from the authenticator app, or a
+// recovery code where those are accepted.
+type TwoFactorCodeRequest struct {
+	Code            string `json:"code"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+func (r TwoFactorSetupRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.CurrentPassword, validation.Required),
+	)
+}
+
+func (r TwoFactorCodeRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Code, validation.Required, validation.Length(6, 32)),
+	)
+}
+
+func (r TwoFactorLoginRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.ChallengeToken, validation.Required),
+		validation.Field(&r.Code, validation.Required, validation.Length(6, 32)),
+	)
+}
@@ -6,19 +6,63 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
+	"gorm.io/gorm"
 )
 
 type User struct {
-	ID        uint      `gorm:"primaryKey"`
-	Name      string    `gorm:"type:varchar(255);not null"`
-	Email     string    `gorm:"uniqueIndex;not null"`
-	Password  string    `gorm:"not null"`
-	CreatedAt time.Time `gorm:"default:current_timestamp"`
+	ID          uint      `gorm:"primaryKey"`
+	Name        string    `gorm:"type:varchar(255);not null"`
+	Email       string    `gorm:"uniqueIndex;not null"`
+	Password    string    `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"default:current_timestamp"`
+	Role        string    `gorm:"type:varchar(16);not null;default:user"`
+	SuspendedAt *time.Time
+	VerifiedAt  *time.Time
+	// VerificationSentAt is when the last verification email was sent,
+	// to limit how often it's resent
+	VerificationSentAt *time.Time
+	// TOTPSecret is the base32 secret of two-factor authentication, which
+	// is on once TOTPEnabledAt is set
+	TOTPSecret    string `gorm:"type:varchar(64)"`
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the time step of the last code used, so a code can't
+	// be used twice
+	TOTPLastStep int64 `gorm:"not null;default:0"`
+	// DeletedAt is set when the user deletes their account, which can be
+	// restored by logging in until it's purged
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (u User) Can(permission Permission) bool {
+	return RoleCan(u.Role, permission)
+}
+
+func (u User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+func (u User) HasTwoFactor() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// IsVerified reports whether the user has confirmed their email address.
+func (u User) IsVerified() bool {
+	return u.VerifiedAt != nil
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required"`
+	Email       string `json:"email" binding:"required,email"`
+	Password    string `json:"password" binding:"required"`
+	ReturnToken bool   `json:"return_token,omitempty"`
+}
+
+// TokenResponse is returned instead of cookies to clients that ask for the
+// tokens in the body, such as CLI tools and mobile apps.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
 }
 
 type RegisterRequest struct {
@@ -29,10 +73,38 @@ type RegisterRequest struct {
 }
 
 type UserResponse struct {
-	ID        uint      `json:"id,omitempty"`
-	Name      string    `json:"name,omitempty"`
-	Email     string    `json:"email,omitempty"`
-	CreatedAt time.Time `json:"createdat,omitempty"`
+	ID          uint       `json:"id,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"createdat,omitempty"`
+	Role        string     `json:"role,omitempty"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+	TwoFactor   bool       `json:"two_factor"`
+}
+
+// NewUserResponse returns the user as shown by the API, without the password.
+func NewUserResponse(u *User) UserResponse {
+	return UserResponse{
+		ID:          u.ID,
+		Name:        u.Name,
+		Email:       u.Email,
+		CreatedAt:   u.CreatedAt,
+		Role:        u.Role,
+		SuspendedAt: u.SuspendedAt,
+		VerifiedAt:  u.VerifiedAt,
+		TwoFactor:   u.HasTwoFactor(),
+	}
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (r RoleRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Role, validation.Required, validation.In(RoleUser, RoleEditor, RoleAdmin)),
+	)
 }
 
 func (l LoginRequest) Validate() error {
@@ -54,14 +126,45 @@ func (r RegisterRequest) Validate() error {
 	)
 }
 
+// ProfileUpdateRequest changes the public profile of a user. The email
+// address and password have their own requests, which need the current
+// password.
 type ProfileUpdateRequest struct {
-	Email string `json:"email" binding:"required,email"`
-	Name  string `json:"name" binding:"required"`
+	Name string `json:"name" binding:"required"`
 }
 
 func (p ProfileUpdateRequest) Validate() error {
 	return validation.ValidateStruct(&p,
-		validation.Field(&p.Email, validation.Required, is.Email),
-		validation.Field(&p.Name, validation.Length(1, 16)),
+		validation.Field(&p.Name, validation.Required, validation.Length(1, 16)),
+	)
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+	PasswordConfirm string `json:"password_confirm"`
+}
+
+func (r ChangePasswordRequest) Validate() error {
+	if r.Password != r.PasswordConfirm {
+		return errors.New("passwords do not match")
+	}
+
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.CurrentPassword, validation.Required),
+		validation.Field(&r.Password, validation.Required, validation.Length(8, 150)),
+		validation.Field(&r.PasswordConfirm, validation.Required, validation.Length(8, 150)),
+	)
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+func (r ChangeEmailRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Email, validation.Required, is.Email),
+		validation.Field(&r.CurrentPassword, validation.Required),
 	)
 }
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// apiKeyTouchInterval is how stale the last use of a key may get, so using a
+// key doesn't write to the database on every request.
+const apiKeyTouchInterval = time.Minute
+
+func (repo AuthRepository) CreateAPIKey(key *model.APIKey) error {
+	tx := repo.db.Create(key)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// FindAPIKeys returns a page of the keys of the user, the newest first.
+func (repo AuthRepository) FindAPIKeys(userID uint, limit, offset int) ([]*model.APIKey, error) {
+	var keys []*model.APIKey
+	tx := repo.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&keys)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return keys, nil
+}
+
+func (repo AuthRepository) CountAPIKeys(userID uint) (int64, error) {
+	var count int64
+	tx := repo.db.Model(&model.APIKey{}).Where("user_id = ?", userID).Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+func (repo AuthRepository) FindAPIKey(keyHash string) (*model.APIKey, error) {
+	var key model.APIKey
+	tx := repo.db.First(&key, "key_hash = ?", keyHash)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &key, nil
+}
+
+// TouchAPIKey records that the key was used, at most once a minute.
+func (repo AuthRepository) TouchAPIKey(keyID uint, now time.Time) error {
+	tx := repo.db.Model(&model.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", keyID, now.Add(-apiKeyTouchInterval)).
+		Update("last_used_at", now)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// DeleteAPIKey revokes a key of the user, returning gorm.ErrRecordNotFound
+// for keys of other users.
+func (repo AuthRepository) DeleteAPIKey(userID, keyID uint) error {
+	tx := repo.db.Where("user_id = ? AND id = ?", userID, keyID).Delete(&model.APIKey{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteUserAPIKeys revokes every key of the user.
+func (repo AuthRepository) DeleteUserAPIKeys(userID uint) error {
+	tx := repo.db.Where("user_id = ?", userID).Delete(&model.APIKey{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
@@ -1,13 +1,45 @@
 package repository
 
 import (
+	"errors"
+	"time"
+
 	"github.com/orhanfatih/blog-api/model"
 	"gorm.io/gorm"
 )
 
+// ErrInvalidPasswordReset is returned for password reset tokens that don't
+// exist, have expired or have been used.
+var ErrInvalidPasswordReset = errors.New("the password reset token is invalid or has expired")
+
 type AuthStore interface {
 	CreateUser(user *model.User) error
 	FindUser(user *model.User, email string) (*model.User, error)
+	EmailTaken(email string) (bool, error)
+	CreatePasswordReset(reset *model.PasswordReset) error
+	CountPasswordResets(userID uint, since time.Time) (int64, error)
+	FindPasswordReset(tokenHash string) (*model.PasswordReset, error)
+	ResetPassword(tokenHash, passwordHash string) (uint, error)
+	SetTOTPSecret(userID uint, secret string) error
+	EnableTOTP(userID uint, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(userID uint) error
+	UseTOTPStep(userID uint, step int64) (bool, error)
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+	CreateLoginChallenge(challenge *model.LoginChallenge) error
+	AttemptLoginChallenge(tokenHash string, maxAttempts int) (*model.LoginChallenge, error)
+	UseLoginChallenge(challengeID uint) error
+	FindIdentity(provider, subject string) (*model.Identity, error)
+	CreateIdentity(identity *model.Identity) error
+	CreateUserWithIdentity(user *model.User, identity *model.Identity) error
+	CreateOIDCState(state *model.OIDCState) error
+	TakeOIDCState(stateHash string) (*model.OIDCState, error)
+	CreateAPIKey(key *model.APIKey) error
+	FindAPIKeys(userID uint, limit, offset int) ([]*model.APIKey, error)
+	CountAPIKeys(userID uint) (int64, error)
+	FindAPIKey(keyHash string) (*model.APIKey, error)
+	TouchAPIKey(keyID uint, now time.Time) error
+	DeleteAPIKey(userID, keyID uint) error
+	DeleteUserAPIKeys(userID uint) error
 }
 
 type AuthRepository struct {
@@ -33,3 +65,94 @@ func (repo AuthRepository) FindUser(user *model.User, email string) (*model.User
 	}
 	return user, nil
 }
+
+// EmailTaken reports whether a user has the email address, counting deleted
+// users, as they keep their address until they are purged.
+func (repo AuthRepository) EmailTaken(email string) (bool, error) {
+	var count int64
+	tx := repo.db.Unscoped().Model(&model.User{}).Where("email = ?", email).Count(&count)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return count > 0, nil
+}
+
+func (repo AuthRepository) CreatePasswordReset(reset *model.PasswordReset) error {
+	tx := repo.db.Create(reset)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// CountPasswordResets counts the password resets asked for by the user since
+// the given time.
+func (repo AuthRepository) CountPasswordResets(userID uint, since time.Time) (int64, error) {
+	var count int64
+	tx := repo.db.Model(&model.PasswordReset{}).Where("user_id = ? AND created_at > ?", userID, since).Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+// FindPasswordReset finds a password reset that can still be used.
+func (repo AuthRepository) FindPasswordReset(tokenHash string) (*model.PasswordReset, error) {
+	var reset model.PasswordReset
+	tx := repo.db.First(&reset, "token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now())
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidPasswordReset
+	}
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &reset, nil
+}
+
+// ResetPassword uses a password reset to set the password of its user and
+// returns the user ID. The other resets of the user stop working, and the
+// email address counts as verified since the token was sent to it.
+func (repo AuthRepository) ResetPassword(tokenHash, passwordHash string) (uint, error) {
+	var userID uint
+	err := repo.db.Transaction(func(db *gorm.DB) error {
+		var reset model.PasswordReset
+		tx := db.First(&reset, "token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now())
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return ErrInvalidPasswordReset
+		}
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		// the update waits for concurrent requests using the token, only
+		// the first one gets to use it
+		now := time.Now()
+		tx = db.Model(&model.PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", now)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return ErrInvalidPasswordReset
+		}
+
+		tx = db.Model(&model.PasswordReset{}).Where("user_id = ? AND used_at IS NULL", reset.UserID).Update("used_at", now)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		tx = db.Model(&model.User{}).Where("id = ?", reset.UserID).Updates(map[string]interface{}{
+			"password":    passwordHash,
+			"verified_at": gorm.Expr("COALESCE(verified_at, ?)", now),
+		})
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		userID = reset.UserID
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBlobNotFound is returned by BlobStore.GetBlob when there is no blob
+// under the key.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the bytes of uploaded media. Keys are made of letters,
+// digits, dashes, underscores, dots and slashes.
+type BlobStore interface {
+	PutBlob(key string, r io.Reader, size int64, contentType string) error
+	GetBlob(key string) (io.ReadCloser, error)
+	DeleteBlob(key string) error
+}
+
+// LocalBlobRepository stores blobs as files under a directory.
+type LocalBlobRepository struct {
+	dir string
+}
+
+func NewLocalBlobRepository(dir string) (*LocalBlobRepository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalBlobRepository{dir: dir}, nil
+}
+
+// PutBlob writes the blob to a temporary file first and renames it, so
+// readers never see a partly written blob.
+func (repo LocalBlobRepository) PutBlob(key string, r io.Reader, size int64, contentType string) error {
+	path, err := repo.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (repo LocalBlobRepository) GetBlob(key string) (io.ReadCloser, error) {
+	path, err := repo.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// DeleteBlob removes the blob. Deleting a missing blob is not an error.
+func (repo LocalBlobRepository) DeleteBlob(key string) error {
+	path, err := repo.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (repo LocalBlobRepository) path(key string) (string, error) {
+	if !validBlobKey(key) {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(repo.dir, filepath.FromSlash(key)), nil
+}
+
+// validBlobKey keeps keys from escaping the directory or bucket they belong
+// to.
+func validBlobKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '/', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
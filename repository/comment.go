@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+type CommentStore interface {
+	CreateComment(comment *model.Comment) error
+	FindComment(postID, commentID int) (*model.Comment, error)
+	FindComments(postID, limit, offset int) ([]*model.Comment, error)
+	FindThreads(postID, limit, offset int) ([]*model.Comment, error)
+	CountComments(postID int, topLevel bool) (int64, error)
+	UpdateComment(comment, updated *model.Comment) (*model.Comment, error)
+	DeleteComment(commentID int) error
+}
+
+type CommentRepository struct {
+	db *gorm.DB
+}
+
+func NewCommentRepository(db *gorm.DB) *CommentRepository {
+	return &CommentRepository{db: db}
+}
+
+// visibleAuthor leaves out the comments of deleted users, which come back if
+// the account is restored. Replies below them are left out of threads too.
+const visibleAuthor = "user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)"
+
+func (repo CommentRepository) CreateComment(comment *model.Comment) error {
+	tx := repo.db.Create(comment)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo CommentRepository) FindComment(postID, commentID int) (*model.Comment, error) {
+	var comment model.Comment
+	tx := repo.db.Where(visibleAuthor).First(&comment, "id = ? AND post_id = ?", commentID, postID)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &comment, nil
+}
+
+// FindComments returns a page of the comments of a post in the order they
+// were written, regardless of nesting.
+func (repo CommentRepository) FindComments(postID, limit, offset int) ([]*model.Comment, error) {
+	var comments []*model.Comment
+	tx := repo.db.Where("post_id = ?", postID).Where(visibleAuthor).Order("created_at, id").Limit(limit).Offset(offset).Find(&comments)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return comments, nil
+}
+
+// FindThreads returns a page of top level comments of a post together with
+// all of their replies, as a flat list ordered by creation time.
+func (repo CommentRepository) FindThreads(postID, limit, offset int) ([]*model.Comment, error) {
+	var comments []*model.Comment
+	tx := repo.db.Raw(`WITH RECURSIVE thread AS (
+		(SELECT * FROM comments WHERE post_id = ? AND parent_id IS NULL AND `+visibleAuthor+` ORDER BY created_at, id LIMIT ? OFFSET ?)
+		UNION ALL
+		SELECT c.* FROM comments c JOIN thread t ON c.parent_id = t.id WHERE c.`+visibleAuthor+`
+	) SELECT * FROM thread ORDER BY created_at, id`, postID, limit, offset).Scan(&comments)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return comments, nil
+}
+
+// CountComments returns the number of comments on a post, or only of those
+// that aren't replies when topLevel is set.
+func (repo CommentRepository) CountComments(postID int, topLevel bool) (int64, error) {
+	var count int64
+	query := repo.db.Model(&model.Comment{}).Where("post_id = ?", postID).Where(visibleAuthor)
+	if topLevel {
+		query = query.Where("parent_id IS NULL")
+	}
+
+	tx := query.Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+func (repo CommentRepository) UpdateComment(comment, updated *model.Comment) (*model.Comment, error) {
+	tx := repo.db.Model(&model.Comment{}).Where("id = ?", comment.ID).Updates(updated)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	comment.Content = updated.Content
+	comment.UpdatedAt = updated.UpdatedAt
+	return comment, nil
+}
+
+func (repo CommentRepository) DeleteComment(commentID int) error {
+	tx := deleteCommentThreads(repo.db, "id = ?", commentID)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return errors.New("commentId doesnt exists")
+	}
+	return nil
+}
+
+// deleteCommentThreads deletes the comments matching the condition along with
+// every reply below them, so no comment is left pointing at a missing parent.
+func deleteCommentThreads(db *gorm.DB, query string, args ...interface{}) *gorm.DB {
+	return db.Exec(`WITH RECURSIVE thread AS (
+		SELECT id FROM comments WHERE `+query+`
+		UNION
+		SELECT c.id FROM comments c JOIN thread t ON c.parent_id = t.id
+	) DELETE FROM comments WHERE id IN (SELECT id FROM thread)`, args...)
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidOIDCState is returned for logins at identity providers that
+// weren't started here, have expired or have already completed.
+var ErrInvalidOIDCState = errors.New("the login is invalid or has expired")
+
+func (repo AuthRepository) FindIdentity(provider, subject string) (*model.Identity, error) {
+	var identity model.Identity
+	tx := repo.db.First(&identity, "provider = ? AND subject = ?", provider, subject)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &identity, nil
+}
+
+func (repo AuthRepository) CreateIdentity(identity *model.Identity) error {
+	tx := repo.db.Create(identity)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// CreateUserWithIdentity registers a user signing in with an identity
+// provider for the first time.
+func (repo AuthRepository) CreateUserWithIdentity(user *model.User, identity *model.Identity) error {
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		tx := db.Create(user)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		identity.UserID = user.ID
+		tx = db.Create(identity)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		return nil
+	})
+}
+
+func (repo AuthRepository) CreateOIDCState(state *model.OIDCState) error {
+	tx := repo.db.Create(state)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// TakeOIDCState deletes and returns a login in progress, so each can only
+// complete once. Expired logins are deleted along the way.
+func (repo AuthRepository) TakeOIDCState(stateHash string) (*model.OIDCState, error) {
+	tx := repo.db.Where("expires_at < ?", time.Now()).Delete(&model.OIDCState{})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var states []*model.OIDCState
+	tx = repo.db.Clauses(clause.Returning{}).Where("state_hash = ?", stateHash).Delete(&states)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if len(states) == 0 {
+		return nil, ErrInvalidOIDCState
+	}
+	return states[0], nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Backoff decides how long a key is locked out after failed logins.
+type Backoff struct {
+	// Free is how many failures in a row are allowed before lockouts start
+	Free int
+	// Base is the first lockout, doubled with every further failure up to Max
+	Base time.Duration
+	Max  time.Duration
+	// Window is how long failures are remembered after the last one
+	Window time.Duration
+}
+
+// Lockout returns how long a key is locked out after its nth failure in a
+// row.
+func (b Backoff) Lockout(failures int) time.Duration {
+	n := failures - b.Free
+	if n <= 0 {
+		return 0
+	}
+	lockout := b.Base
+	for i := 1; i < n && lockout < b.Max; i++ {
+		lockout *= 2
+	}
+	if lockout > b.Max {
+		lockout = b.Max
+	}
+	return lockout
+}
+
+// LoginAttemptStore counts failed logins to lock out those guessing
+// passwords.
+type LoginAttemptStore interface {
+	// FindLoginAttempt returns the failures of a key, none if it has no
+	// record
+	FindLoginAttempt(key string) (*model.LoginAttempt, error)
+	// FailLogin counts a failed login of a key and locks it out as long as
+	// the backoff says
+	FailLogin(key string, backoff Backoff, now time.Time) (*model.LoginAttempt, error)
+	// ResetLogin forgets the failures of a key
+	ResetLogin(key string) error
+	// PruneLoginAttempts forgets keys that haven't failed since before and
+	// aren't locked out anymore
+	PruneLoginAttempts(before time.Time) (int64, error)
+}
+
+// LoginAttemptRepository keeps the counters in PostgreSQL, so they are shared
+// by every instance of the API and survive restarts.
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+func (repo LoginAttemptRepository) FindLoginAttempt(key string) (*model.LoginAttempt, error) {
+	var attempt model.LoginAttempt
+	tx := repo.db.First(&attempt, "key = ?", key)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return &model.LoginAttempt{Key: key}, nil
+	}
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &attempt, nil
+}
+
+// FailLogin counts the failure with an upsert, so concurrent failures are
+// all counted, and only ever extends a lockout.
+func (repo LoginAttemptRepository) FailLogin(key string, backoff Backoff, now time.Time) (*model.LoginAttempt, error) {
+	attempt := model.LoginAttempt{Key: key, Failures: 1, LastFailedAt: now, LockedUntil: now.Add(backoff.Lockout(1))}
+	tx := repo.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":       gorm.Expr("CASE WHEN login_attempts.last_failed_at < ? THEN 1 ELSE login_attempts.failures + 1 END", now.Add(-backoff.Window)),
+			"last_failed_at": now,
+		}),
+	}, clause.Returning{}).Create(&attempt)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	lockedUntil := now.Add(backoff.Lockout(attempt.Failures))
+	if !lockedUntil.After(attempt.LockedUntil) {
+		return &attempt, nil
+	}
+	tx = repo.db.Model(&model.LoginAttempt{}).
+		Where("key = ?", key).
+		Update("locked_until", gorm.Expr("GREATEST(locked_until, ?)", lockedUntil))
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	attempt.LockedUntil = lockedUntil
+	return &attempt, nil
+}
+
+func (repo LoginAttemptRepository) ResetLogin(key string) error {
+	tx := repo.db.Where("key = ?", key).Delete(&model.LoginAttempt{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo LoginAttemptRepository) PruneLoginAttempts(before time.Time) (int64, error) {
+	tx := repo.db.Where("last_failed_at < ? AND locked_until < ?", before, time.Now()).Delete(&model.LoginAttempt{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return tx.RowsAffected, nil
+}
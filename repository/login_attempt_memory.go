@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+)
+
+// MemoryLoginAttemptRepository keeps the counters in memory, for a single
+// instance of the API. They are lost on restart.
+type MemoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]model.LoginAttempt
+}
+
+func NewMemoryLoginAttemptRepository() *MemoryLoginAttemptRepository {
+	return &MemoryLoginAttemptRepository{attempts: make(map[string]model.LoginAttempt)}
+}
+
+func (repo *MemoryLoginAttemptRepository) FindLoginAttempt(key string) (*model.LoginAttempt, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	attempt, ok := repo.attempts[key]
+	if !ok {
+		attempt = model.LoginAttempt{Key: key}
+	}
+	return &attempt, nil
+}
+
+func (repo *MemoryLoginAttemptRepository) FailLogin(key string, backoff Backoff, now time.Time) (*model.LoginAttempt, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	attempt, ok := repo.attempts[key]
+	if !ok || attempt.LastFailedAt.Before(now.Add(-backoff.Window)) {
+		attempt.Failures = 0
+	}
+	attempt.Key = key
+	attempt.Failures++
+	attempt.LastFailedAt = now
+	if lockedUntil := now.Add(backoff.Lockout(attempt.Failures)); lockedUntil.After(attempt.LockedUntil) {
+		attempt.LockedUntil = lockedUntil
+	}
+	repo.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (repo *MemoryLoginAttemptRepository) ResetLogin(key string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.attempts, key)
+	return nil
+}
+
+func (repo *MemoryLoginAttemptRepository) PruneLoginAttempts(before time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for key, attempt := range repo.attempts {
+		if attempt.LastFailedAt.Before(before) && !attempt.IsLocked(now) {
+			delete(repo.attempts, key)
+			n++
+		}
+	}
+	return n, nil
+}
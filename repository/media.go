@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// ErrMediaInUse is returned by DeleteMedia when a post uses the media.
+var ErrMediaInUse = errors.New("media is used by a post")
+
+type MediaStore interface {
+	CreateMedia(media *model.Media) error
+	FindMedia(mediaID int) (*model.Media, error)
+	FindMediaByIDs(ids []uint) ([]*model.Media, error)
+	IsMediaPublished(mediaID uint) (bool, error)
+	FindUnreferencedMedia(before time.Time, limit int) ([]*model.Media, error)
+	DeleteMedia(mediaID uint) error
+	FindPendingMedia(limit int) ([]*model.Media, error)
+	SaveMediaVariants(mediaID uint, variants []*model.MediaVariant) error
+	SetMediaStatus(mediaID uint, status string) error
+}
+
+type MediaRepository struct {
+	db *gorm.DB
+}
+
+func NewMediaRepository(db *gorm.DB) *MediaRepository {
+	return &MediaRepository{db: db}
+}
+
+func (repo MediaRepository) CreateMedia(media *model.Media) error {
+	tx := repo.db.Create(media)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo MediaRepository) FindMedia(mediaID int) (*model.Media, error) {
+	var media model.Media
+	tx := repo.db.Preload("Variants").First(&media, "id = ?", mediaID)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &media, nil
+}
+
+func (repo MediaRepository) FindMediaByIDs(ids []uint) ([]*model.Media, error) {
+	var media []*model.Media
+	if len(ids) == 0 {
+		return media, nil
+	}
+
+	tx := repo.db.Where("id IN ?", ids).Find(&media)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return media, nil
+}
+
+// IsMediaPublished reports whether a published post uses the media.
+func (repo MediaRepository) IsMediaPublished(mediaID uint) (bool, error) {
+	var count int64
+	tx := repo.db.Table("post_media").
+		Joins("JOIN posts ON posts.id = post_media.post_id").
+		Where("post_media.media_id = ? AND posts.status = ? AND posts.deleted_at IS NULL", mediaID, model.PostStatusPublished).
+		Count(&count)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return count > 0, nil
+}
+
+// FindUnreferencedMedia returns media uploaded before the given time that no
+// post uses, oldest first.
+func (repo MediaRepository) FindUnreferencedMedia(before time.Time, limit int) ([]*model.Media, error) {
+	var media []*model.Media
+	tx := repo.db.Preload("Variants").
+		Where("created_at < ? AND NOT EXISTS (SELECT 1 FROM post_media WHERE post_media.media_id = media.id)", before).
+		Order("created_at").Limit(limit).Find(&media)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return media, nil
+}
+
+// DeleteMedia deletes the media and its variants unless a post uses it.
+func (repo MediaRepository) DeleteMedia(mediaID uint) error {
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		tx := db.Where("media_id = ?", mediaID).Delete(&model.MediaVariant{})
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		tx = db.Where("id = ? AND NOT EXISTS (SELECT 1 FROM post_media WHERE post_media.media_id = media.id)", mediaID).
+			Delete(&model.Media{})
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return ErrMediaInUse
+		}
+		return nil
+	})
+}
+
+// FindPendingMedia returns media waiting for their variants, oldest first.
+func (repo MediaRepository) FindPendingMedia(limit int) ([]*model.Media, error) {
+	var media []*model.Media
+	tx := repo.db.Where("status = ?", model.MediaStatusPending).Order("created_at").Limit(limit).Find(&media)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return media, nil
+}
+
+// SaveMediaVariants stores the generated variants of the media and marks it
+// ready.
+func (repo MediaRepository) SaveMediaVariants(mediaID uint, variants []*model.MediaVariant) error {
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		for _, v := range variants {
+			v.MediaID = mediaID
+		}
+
+		tx := db.Where("media_id = ?", mediaID).Delete(&model.MediaVariant{})
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		tx = db.Create(&variants)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		return setMediaStatus(db, mediaID, model.MediaStatusReady)
+	})
+}
+
+func (repo MediaRepository) SetMediaStatus(mediaID uint, status string) error {
+	return setMediaStatus(repo.db, mediaID, status)
+}
+
+func setMediaStatus(db *gorm.DB, mediaID uint, status string) error {
+	tx := db.Model(&model.Media{}).Where("id = ?", mediaID).Update("status", status)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
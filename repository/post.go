@@ -2,6 +2,7 @@ package repository
 
 import (
 	"errors"
+	"time"
 
 	"github.com/orhanfatih/blog-api/model"
 	"gorm.io/gorm"
@@ -10,9 +11,32 @@ import (
 type PostStore interface {
 	CreatePost(post *model.Post) error
 	FindPost(post *model.Post, postID int) (*model.Post, error)
+	FindPostBySlug(slug string) (*model.Post, error)
+	FindPostByOldSlug(slug string) (*model.Post, error)
 	UpdatePost(post, updated *model.Post) (*model.Post, error)
 	DeletePost(postId int) error
-	FindPosts(limit, offset int) ([]*model.Post, error)
+	RestorePost(postID int) error
+	PurgeDeletedPosts(before time.Time) (int64, error)
+	FindPosts(filter PostFilter) ([]*model.Post, error)
+	CountPosts(filter PostFilter) (int64, error)
+	PublishDuePosts(now time.Time) (int64, error)
+}
+
+// PostFilter narrows down and pages through the posts returned by FindPosts.
+// Posts are ordered newest first. When After is set, Offset is ignored and
+// only the posts after that position are returned.
+type PostFilter struct {
+	Tag    string
+	Status string
+	Limit  int
+	Offset int
+	After  *PostCursor
+}
+
+// PostCursor is the position of a post in the newest first order.
+type PostCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uint      `json:"id"`
 }
 
 type PostRepository struct {
@@ -24,15 +48,60 @@ func NewPostRepository(db *gorm.DB) *PostRepository {
 }
 
 func (repo PostRepository) CreatePost(post *model.Post) error {
-	tx := repo.db.Create(post)
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		tags, err := resolveTags(db, post.Tags)
+		if err != nil {
+			return err
+		}
+		post.Tags = tags
+
+		slug, err := uniqueSlug(db, model.Slugify(post.Title), post.ID)
+		if err != nil {
+			return err
+		}
+		post.Slug = slug
+
+		post.Revision = 1
+		tx := db.Omit("Tags.*", "Media.*").Create(post)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		return createRevision(db, post)
+	})
+}
+
+// Migrate drops the unique index on titles, which slugs replace, and gives a
+// slug to the posts created before slugs existed.
+func (repo PostRepository) Migrate() error {
+	migrator := repo.db.Migrator()
+	if migrator.HasIndex(&model.Post{}, "idx_posts_title") {
+		if err := migrator.DropIndex(&model.Post{}, "idx_posts_title"); err != nil {
+			return err
+		}
+	}
+
+	var posts []*model.Post
+	tx := repo.db.Where("slug IS NULL OR slug = ''").Order("id").Find(&posts)
 	if tx.Error != nil {
 		return tx.Error
 	}
+
+	for _, post := range posts {
+		slug, err := uniqueSlug(repo.db, model.Slugify(post.Title), post.ID)
+		if err != nil {
+			return err
+		}
+		tx = repo.db.Model(&model.Post{}).Where("id = ?", post.ID).UpdateColumn("slug", slug)
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
 	return nil
 }
 
 func (repo PostRepository) FindPost(post *model.Post, postID int) (*model.Post, error) {
-	tx := repo.db.First(&post, "id = ?", postID)
+	tx := repo.db.Preload("Tags").Preload("Media.Variants").First(&post, "id = ?", postID)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
@@ -42,14 +111,90 @@ func (repo PostRepository) FindPost(post *model.Post, postID int) (*model.Post,
 	return post, nil
 }
 
-func (repo PostRepository) UpdatePost(post, updated *model.Post) (*model.Post, error) {
-	tx := repo.db.Model(&model.Post{}).Where("id = ?", post.ID).Updates(updated).Scan(&updated)
+func (repo PostRepository) FindPostBySlug(slug string) (*model.Post, error) {
+	var post model.Post
+	tx := repo.db.Preload("Tags").Preload("Media.Variants").First(&post, "slug = ?", slug)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
-	return updated, nil
+	return &post, nil
 }
 
+// FindPostByOldSlug finds the post that used the slug before its title
+// changed.
+func (repo PostRepository) FindPostByOldSlug(slug string) (*model.Post, error) {
+	var post model.Post
+	tx := repo.db.Preload("Tags").Preload("Media.Variants").
+		Joins("JOIN post_slugs ON post_slugs.post_id = posts.id").
+		First(&post, "post_slugs.slug = ?", slug)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &post, nil
+}
+
+// UpdatePost applies the non-zero fields of updated to post. The tags and
+// media of the post are replaced when updated.Tags or updated.Media is not
+// nil. Changes to the title, content or content format are recorded as a new
+// revision, and a new title gives the post a new slug.
+func (repo PostRepository) UpdatePost(post, updated *model.Post) (*model.Post, error) {
+	err := repo.db.Transaction(func(db *gorm.DB) error {
+		if updated.Title != "" && updated.Title != post.Title {
+			if err := changeSlug(db, post, updated); err != nil {
+				return err
+			}
+		}
+
+		tx := db.Model(&model.Post{}).Where("id = ?", post.ID).Omit("Tags", "Media", "Revision").Updates(updated)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		if updated.Title != "" || updated.Content != "" || updated.ContentFormat != "" {
+			// the increment locks the row until the transaction ends, so
+			// concurrent edits get consecutive revision numbers
+			tx = db.Model(&model.Post{}).Where("id = ?", post.ID).Update("revision", gorm.Expr("revision + 1"))
+			if tx.Error != nil {
+				return tx.Error
+			}
+
+			var current model.Post
+			tx = db.First(&current, "id = ?", post.ID)
+			if tx.Error != nil {
+				return tx.Error
+			}
+			if err := createRevision(db, &current); err != nil {
+				return err
+			}
+		}
+
+		if updated.Tags != nil {
+			tags, err := resolveTags(db, updated.Tags)
+			if err != nil {
+				return err
+			}
+			if err := db.Model(post).Omit("Tags.*").Association("Tags").Replace(tags); err != nil {
+				return err
+			}
+		}
+
+		if updated.Media != nil {
+			if err := db.Model(post).Omit("Media.*").Association("Media").Replace(updated.Media); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var p *model.Post
+	return repo.FindPost(p, int(post.ID))
+}
+
+// DeletePost deletes the post, keeping it along with its comments, tags and
+// history until PurgeDeletedPosts so it can be restored meanwhile.
 func (repo PostRepository) DeletePost(postId int) error {
 	tx := repo.db.Delete(&model.Post{}, "id = ?", postId)
 	if tx.Error != nil {
@@ -61,11 +206,129 @@ func (repo PostRepository) DeletePost(postId int) error {
 	return nil
 }
 
-func (repo PostRepository) FindPosts(limit, offset int) ([]*model.Post, error) {
+// RestorePost brings back a deleted post, returning gorm.ErrRecordNotFound
+// when there is no such post or its author is deleted too. The posts of
+// deleted users come back with the user.
+func (repo PostRepository) RestorePost(postID int) error {
+	users := repo.db.Model(&model.User{}).Select("id")
+	tx := repo.db.Unscoped().Model(&model.Post{}).
+		Where("id = ? AND deleted_at IS NOT NULL AND user_id IN (?)", postID, users).
+		UpdateColumn("deleted_at", nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// PurgeDeletedPosts permanently deletes the posts deleted before the given
+// time along with their comments, tags and history, and returns how many
+// there were.
+func (repo PostRepository) PurgeDeletedPosts(before time.Time) (int64, error) {
+	var ids []uint
+	tx := repo.db.Unscoped().Model(&model.Post{}).Where("deleted_at < ?", before).Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	err := repo.db.Transaction(func(db *gorm.DB) error {
+		return purgePosts(db, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// purgePosts permanently deletes the posts with the IDs, a list or a
+// subquery, along with everything belonging to them.
+func purgePosts(db *gorm.DB, ids interface{}) error {
+	tx := db.Where("post_id IN (?)", ids).Delete(&model.Comment{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Exec("DELETE FROM post_tags WHERE post_id IN (?)", ids)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Exec("DELETE FROM post_media WHERE post_id IN (?)", ids)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("post_id IN (?)", ids).Delete(&model.PostRevision{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("post_id IN (?)", ids).Delete(&model.PostSlug{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Unscoped().Where("id IN (?)", ids).Delete(&model.Post{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo PostRepository) FindPosts(filter PostFilter) ([]*model.Post, error) {
 	var posts []*model.Post
-	tx := repo.db.Limit(limit).Offset(offset).Find(&posts)
+	query := repo.filterPosts(filter).Preload("Tags").Preload("Media.Variants")
+	if filter.After != nil {
+		query = query.Where("(created_at, id) < (?, ?)", filter.After.CreatedAt, filter.After.ID)
+	} else {
+		query = query.Offset(filter.Offset)
+	}
+
+	tx := query.Order("created_at DESC, id DESC").Limit(filter.Limit).Find(&posts)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
 	return posts, nil
 }
+
+// CountPosts returns the number of posts matching the filter, ignoring its
+// paging fields.
+func (repo PostRepository) CountPosts(filter PostFilter) (int64, error) {
+	var count int64
+	tx := repo.filterPosts(filter).Model(&model.Post{}).Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+func (repo PostRepository) filterPosts(filter PostFilter) *gorm.DB {
+	query := repo.db
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Tag != "" {
+		tagged := repo.db.Table("post_tags").Select("post_tags.post_id").
+			Joins("JOIN tags ON tags.id = post_tags.tag_id").
+			Where("tags.name = ?", filter.Tag)
+		query = query.Where("id IN (?)", tagged)
+	}
+	return query
+}
+
+// PublishDuePosts publishes the scheduled posts whose publish time has come
+// and returns how many there were.
+func (repo PostRepository) PublishDuePosts(now time.Time) (int64, error) {
+	tx := repo.db.Model(&model.Post{}).
+		Where("status = ? AND publish_at <= ?", model.PostStatusScheduled, now).
+		Updates(map[string]interface{}{"status": model.PostStatusPublished, "updated_at": now})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return tx.RowsAffected, nil
+}
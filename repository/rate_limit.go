@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimit lets a client make Requests requests per Period. Unused requests
+// add up to Requests, which can then be made at once.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitResult tells whether a request was allowed and how the bucket of
+// the client looks after it.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next request is allowed, 0 if it
+	// already is
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// take refills the bucket for the time since it was last refilled and takes
+// a token out of it if there is one.
+func (l RateLimit) take(bucket *model.RateLimitBucket, now time.Time) RateLimitResult {
+	interval := float64(l.Period) / float64(l.Requests)
+	if elapsed := now.Sub(bucket.RefilledAt); elapsed > 0 {
+		bucket.Tokens += float64(elapsed) / interval
+		bucket.RefilledAt = now
+	}
+	if bucket.Tokens > float64(l.Requests) {
+		bucket.Tokens = float64(l.Requests)
+	}
+
+	result := RateLimitResult{Allowed: bucket.Tokens >= 1}
+	if result.Allowed {
+		bucket.Tokens--
+	} else {
+		result.RetryAfter = time.Duration((1 - bucket.Tokens) * interval)
+	}
+	result.Remaining = int(bucket.Tokens)
+	result.Reset = time.Duration((float64(l.Requests) - bucket.Tokens) * interval)
+	return result
+}
+
+// RateLimitStore keeps the token buckets of clients.
+type RateLimitStore interface {
+	// TakeToken takes a token out of the bucket of a key for a request,
+	// filling a new bucket for keys without one
+	TakeToken(key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+	// PruneRateLimits forgets buckets that haven't been used since before
+	PruneRateLimits(before time.Time) (int64, error)
+}
+
+// RateLimitRepository keeps the buckets in PostgreSQL, so every instance of
+// the API shares them.
+type RateLimitRepository struct {
+	db *gorm.DB
+}
+
+func NewRateLimitRepository(db *gorm.DB) *RateLimitRepository {
+	return &RateLimitRepository{db: db}
+}
+
+// TakeToken locks the bucket while it's updated, so concurrent requests
+// can't take the same token. Clients without a bucket may get one request
+// more when their first requests race.
+func (repo RateLimitRepository) TakeToken(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	var result RateLimitResult
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var bucket model.RateLimitBucket
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&bucket, "key = ?", key)
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			bucket = model.RateLimitBucket{Key: key, Tokens: float64(limit.Requests), RefilledAt: now}
+		} else if res.Error != nil {
+			return res.Error
+		}
+
+		result = limit.take(&bucket, now)
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&bucket).Error
+	})
+	return result, err
+}
+
+func (repo RateLimitRepository) PruneRateLimits(before time.Time) (int64, error) {
+	tx := repo.db.Where("refilled_at < ?", before).Delete(&model.RateLimitBucket{})
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return tx.RowsAffected, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+)
+
+// MemoryRateLimitRepository keeps the buckets in memory, for a single
+// instance of the API.
+type MemoryRateLimitRepository struct {
+	mu      sync.Mutex
+	buckets map[string]*model.RateLimitBucket
+}
+
+func NewMemoryRateLimitRepository() *MemoryRateLimitRepository {
+	return &MemoryRateLimitRepository{buckets: make(map[string]*model.RateLimitBucket)}
+}
+
+func (repo *MemoryRateLimitRepository) TakeToken(key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	bucket, ok := repo.buckets[key]
+	if !ok {
+		bucket = &model.RateLimitBucket{Key: key, Tokens: float64(limit.Requests), RefilledAt: now}
+		repo.buckets[key] = bucket
+	}
+	return limit.take(bucket, now), nil
+}
+
+func (repo *MemoryRateLimitRepository) PruneRateLimits(before time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var n int64
+	for key, bucket := range repo.buckets {
+		if bucket.RefilledAt.Before(before) {
+			delete(repo.buckets, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// RevisionStore reads the revisions PostStore records when posts are created
+// and edited.
+type RevisionStore interface {
+	FindRevision(postID, revision int) (*model.PostRevision, error)
+	FindRevisions(postID, limit, offset int) ([]*model.PostRevision, error)
+	CountRevisions(postID int) (int64, error)
+}
+
+type RevisionRepository struct {
+	db *gorm.DB
+}
+
+func NewRevisionRepository(db *gorm.DB) *RevisionRepository {
+	return &RevisionRepository{db: db}
+}
+
+// Migrate records the current state of posts created before revisions
+// existed as their first revision.
+func (repo RevisionRepository) Migrate() error {
+	tx := repo.db.Exec(`INSERT INTO post_revisions (post_id, revision, title, content, content_format, created_at)
+		SELECT p.id, p.revision, p.title, p.content, p.content_format, p.updated_at FROM posts p
+		WHERE NOT EXISTS (SELECT 1 FROM post_revisions r WHERE r.post_id = p.id)`)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo RevisionRepository) FindRevision(postID, revision int) (*model.PostRevision, error) {
+	var rev model.PostRevision
+	tx := repo.db.First(&rev, "post_id = ? AND revision = ?", postID, revision)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &rev, nil
+}
+
+// FindRevisions returns a page of the revisions of a post, latest first.
+func (repo RevisionRepository) FindRevisions(postID, limit, offset int) ([]*model.PostRevision, error) {
+	var revisions []*model.PostRevision
+	tx := repo.db.Where("post_id = ?", postID).Order("revision DESC").Limit(limit).Offset(offset).Find(&revisions)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return revisions, nil
+}
+
+func (repo RevisionRepository) CountRevisions(postID int) (int64, error) {
+	var count int64
+	tx := repo.db.Model(&model.PostRevision{}).Where("post_id = ?", postID).Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+func createRevision(db *gorm.DB, post *model.Post) error {
+	tx := db.Create(&model.PostRevision{
+		PostID:        post.ID,
+		Revision:      post.Revision,
+		Title:         post.Title,
+		Content:       post.Content,
+		ContentFormat: post.ContentFormat,
+		CreatedAt:     post.UpdatedAt,
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
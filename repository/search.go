@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// SearchStore finds posts by their text. It is kept apart from PostStore so
+// the PostgreSQL implementation can be swapped for an external search engine.
+type SearchStore interface {
+	SearchPosts(query string, limit, offset int) ([]*model.SearchResult, error)
+	CountSearchResults(query string) (int64, error)
+}
+
+// SearchRepository implements SearchStore with PostgreSQL full-text search.
+type SearchRepository struct {
+	db *gorm.DB
+}
+
+func NewSearchRepository(db *gorm.DB) *SearchRepository {
+	return &SearchRepository{db: db}
+}
+
+// Migrate adds the generated search_vector column and its GIN index to the
+// posts table. Titles weigh more than content when ranking.
+func (repo SearchRepository) Migrate() error {
+	tx := repo.db.Exec(`ALTER TABLE posts ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(content, '')), 'B')
+		) STORED`)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = repo.db.Exec("CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING GIN (search_vector)")
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// SearchPosts returns the published posts matching a web search style query
+// (quoted phrases, OR, -word) ordered by relevance. The snippet is built from
+// the HTML escaped content so the only markup in it is the <mark> tags.
+func (repo SearchRepository) SearchPosts(query string, limit, offset int) ([]*model.SearchResult, error) {
+	var results []*model.SearchResult
+	tx := repo.db.Raw(`SELECT posts.*,
+			ts_rank(posts.search_vector, q) AS rank,
+			ts_headline('english',
+				replace(replace(replace(posts.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+				q, 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15') AS snippet
+		FROM posts, websearch_to_tsquery('english', ?) q
+		WHERE posts.search_vector @@ q AND posts.status = ? AND posts.deleted_at IS NULL
+		ORDER BY rank DESC, posts.id DESC
+		LIMIT ? OFFSET ?`, query, model.PostStatusPublished, limit, offset).Scan(&results)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return results, nil
+}
+
+func (repo SearchRepository) CountSearchResults(query string) (int64, error) {
+	var count int64
+	tx := repo.db.Raw(`SELECT COUNT(*) FROM posts, websearch_to_tsquery('english', ?) q
+		WHERE posts.search_vector @@ q AND posts.status = ? AND posts.deleted_at IS NULL`, query, model.PostStatusPublished).Scan(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+var ErrSessionReused = errors.New("refresh token has already been used")
+
+type SessionStore interface {
+	CreateSession(session *model.Session) error
+	FindSession(tokenHash string) (*model.Session, error)
+	RotateSession(old, next *model.Session) error
+	RevokeFamily(familyID string) error
+	RevokeUserSessions(userID uint) error
+	RevokeOtherSessions(userID uint, familyID string) error
+	IsActive(familyID string) (bool, error)
+}
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (repo SessionRepository) CreateSession(session *model.Session) error {
+	tx := repo.db.Create(session)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo SessionRepository) FindSession(tokenHash string) (*model.Session, error) {
+	var session model.Session
+	tx := repo.db.First(&session, "token_hash = ?", tokenHash)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &session, nil
+}
+
+// RotateSession marks old as used and stores next in its place. It returns
+// ErrSessionReused if old was already used or revoked in the meantime, so two
+// concurrent refreshes with the same token can't both succeed.
+func (repo SessionRepository) RotateSession(old, next *model.Session) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Session{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", old.ID).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrSessionReused
+		}
+
+		return tx.Create(next).Error
+	})
+}
+
+func (repo SessionRepository) RevokeFamily(familyID string) error {
+	tx := repo.db.Model(&model.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// RevokeUserSessions signs the user out everywhere.
+func (repo SessionRepository) RevokeUserSessions(userID uint) error {
+	tx := repo.db.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere but in the given session.
+func (repo SessionRepository) RevokeOtherSessions(userID uint, familyID string) error {
+	tx := repo.db.Model(&model.Session{}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (repo SessionRepository) IsActive(familyID string) (bool, error) {
+	var count int64
+	tx := repo.db.Model(&model.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL AND expires_at > ?", familyID, time.Now()).
+		Count(&count)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// uniqueSlug returns base, or base followed by the lowest free number, such
+// that no other post uses the slug now or used it before. Slugs only contain
+// letters, digits and dashes, so base needs no escaping in LIKE.
+//
+// Called in a transaction, it holds a lock on base until the transaction
+// ends, so posts created or renamed at the same time don't pick the same slug.
+func uniqueSlug(db *gorm.DB, base string, postID uint) (string, error) {
+	tx := db.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "slug:"+base)
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+
+	var taken []string
+	tx = db.Raw(`SELECT slug FROM posts WHERE (slug = ? OR slug LIKE ?) AND id <> ?
+		UNION SELECT slug FROM post_slugs WHERE (slug = ? OR slug LIKE ?) AND post_id <> ?`,
+		base, base+"-%", postID, base, base+"-%", postID).Scan(&taken)
+	if tx.Error != nil {
+		return "", tx.Error
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, slug := range taken {
+		used[slug] = true
+	}
+
+	slug := base
+	for i := 2; used[slug]; i++ {
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+	return slug, nil
+}
+
+// changeSlug sets the slug for the new title of post in updated and keeps
+// the current one in the slug history. A post getting back one of its old
+// slugs takes it out of the history.
+func changeSlug(db *gorm.DB, post, updated *model.Post) error {
+	slug, err := uniqueSlug(db, model.Slugify(updated.Title), post.ID)
+	if err != nil {
+		return err
+	}
+	if slug == post.Slug {
+		return nil
+	}
+
+	tx := db.Where("post_id = ? AND slug = ?", post.ID, slug).Delete(&model.PostSlug{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if post.Slug != "" {
+		tx = db.Create(&model.PostSlug{PostID: post.ID, Slug: post.Slug})
+		if tx.Error != nil {
+			return tx.Error
+		}
+	}
+
+	updated.Slug = slug
+	return nil
+}
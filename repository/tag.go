@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TagStore interface {
+	FindTags(limit, offset int) ([]*model.TagCount, error)
+	CountTags() (int64, error)
+}
+
+type TagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+// FindTags returns every tag in use with the number of published posts tagged
+// with it, most used first.
+func (repo TagRepository) FindTags(limit, offset int) ([]*model.TagCount, error) {
+	var tags []*model.TagCount
+	tx := repo.db.Table("tags").
+		Select("tags.name, COUNT(post_tags.post_id) AS count").
+		Joins("JOIN post_tags ON post_tags.tag_id = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.status = ? AND posts.deleted_at IS NULL", model.PostStatusPublished).
+		Group("tags.name").
+		Order("count DESC, tags.name").
+		Limit(limit).Offset(offset).
+		Scan(&tags)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return tags, nil
+}
+
+// CountTags returns the number of tags in use.
+func (repo TagRepository) CountTags() (int64, error) {
+	var count int64
+	tx := repo.db.Table("post_tags").
+		Joins("JOIN posts ON posts.id = post_tags.post_id AND posts.status = ? AND posts.deleted_at IS NULL", model.PostStatusPublished).
+		Distinct("post_tags.tag_id").
+		Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+// resolveTags replaces tags that only have a name with the stored tags,
+// creating the ones that don't exist yet.
+func resolveTags(db *gorm.DB, tags []*model.Tag) ([]*model.Tag, error) {
+	if len(tags) == 0 {
+		return tags, nil
+	}
+
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+
+	tx := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "name"}}, DoNothing: true}).Create(&tags)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var stored []*model.Tag
+	tx = db.Where("name IN ?", names).Find(&stored)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return stored, nil
+}
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidLoginChallenge is returned for login challenges that don't exist,
+// have expired, have been used or ran out of attempts.
+var ErrInvalidLoginChallenge = errors.New("the login challenge is invalid or has expired")
+
+// SetTOTPSecret stores the secret of a two-factor setup that isn't confirmed
+// yet, replacing any earlier one.
+func (repo AuthRepository) SetTOTPSecret(userID uint, secret string) error {
+	tx := repo.db.Model(&model.User{}).Where("id = ? AND totp_enabled_at IS NULL", userID).Update("totp_secret", secret)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// EnableTOTP turns two-factor authentication on, replacing the recovery codes
+// of the user.
+func (repo AuthRepository) EnableTOTP(userID uint, step int64, recoveryCodeHashes []string) error {
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		tx := db.Model(&model.User{}).Where("id = ? AND totp_enabled_at IS NULL", userID).Updates(map[string]interface{}{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		})
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return errors.New("two-factor authentication is already on")
+		}
+
+		return replaceRecoveryCodes(db, userID, recoveryCodeHashes)
+	})
+}
+
+// DisableTOTP turns two-factor authentication off and deletes the recovery
+// codes of the user.
+func (repo AuthRepository) DisableTOTP(userID uint) error {
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		tx := db.Model(&model.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		})
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		return replaceRecoveryCodes(db, userID, nil)
+	})
+}
+
+func replaceRecoveryCodes(db *gorm.DB, userID uint, hashes []string) error {
+	tx := db.Where("user_id = ?", userID).Delete(&model.RecoveryCode{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+	codes := make([]*model.RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, &model.RecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	tx = db.Create(&codes)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code of a time step was used. It reports
+// false when a code of that step or a later one was already used.
+func (repo AuthRepository) UseTOTPStep(userID uint, step int64) (bool, error) {
+	tx := repo.db.Model(&model.User{}).Where("id = ? AND totp_last_step < ?", userID, step).Update("totp_last_step", step)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks a recovery code of the user as used. It reports false
+// when there is no such unused code.
+func (repo AuthRepository) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	tx := repo.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected > 0, nil
+}
+
+func (repo AuthRepository) CreateLoginChallenge(challenge *model.LoginChallenge) error {
+	tx := repo.db.Create(challenge)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// AttemptLoginChallenge counts an attempt at a login challenge and returns
+// it, as long as it's unused, unexpired and has attempts left.
+func (repo AuthRepository) AttemptLoginChallenge(tokenHash string, maxAttempts int) (*model.LoginChallenge, error) {
+	var challenge model.LoginChallenge
+	tx := repo.db.Model(&challenge).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", tokenHash, time.Now(), maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	tx = repo.db.First(&challenge, "token_hash = ?", tokenHash)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &challenge, nil
+}
+
+// UseLoginChallenge marks a login challenge as used, returning
+// ErrInvalidLoginChallenge when it already was.
+func (repo AuthRepository) UseLoginChallenge(challengeID uint) error {
+	tx := repo.db.Model(&model.LoginChallenge{}).Where("id = ? AND used_at IS NULL", challengeID).Update("used_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrInvalidLoginChallenge
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/orhanfatih/blog-api/model"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -9,7 +11,18 @@ import (
 type UserStore interface {
 	FindUser(userID int) (*model.User, error)
 	UpdateUser(userID int, updated *model.User) (*model.User, error)
-	DeleteUser(user *model.User) error
+	DeleteUser(user *model.User, suspend bool) error
+	FindDeletedUser(userID int, since time.Time) (*model.User, error)
+	FindDeletedUserByEmail(email string, since time.Time) (*model.User, error)
+	RestoreUser(userID int) error
+	PurgeDeletedUsers(before time.Time) (int64, error)
+	FindUsers(role string, limit, offset int) ([]*model.User, error)
+	CountUsers(role string) (int64, error)
+	SetUserRole(userID int, role string) error
+	SetUserSuspended(userID int, suspendedAt *time.Time) error
+	SetUserPassword(userID int, passwordHash string) error
+	VerifyUser(userID int) error
+	ReserveVerificationEmail(userID int, since time.Time) (bool, error)
 }
 
 type UserRepository struct {
@@ -20,6 +33,25 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// Migrate marks the users registered before email verification existed as
+// verified. It runs before the verified_at column is added by AutoMigrate, to
+// tell those users apart from new ones.
+func (repo UserRepository) Migrate() error {
+	migrator := repo.db.Migrator()
+	if !migrator.HasTable(&model.User{}) || migrator.HasColumn(&model.User{}, "VerifiedAt") {
+		return nil
+	}
+
+	if err := migrator.AddColumn(&model.User{}, "VerifiedAt"); err != nil {
+		return err
+	}
+	tx := repo.db.Exec("UPDATE users SET verified_at = created_at")
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
 func (repo UserRepository) FindUser(userID int) (*model.User, error) {
 	var me *model.User
 	tx := repo.db.First(&me, "id = ?", userID)
@@ -38,13 +70,210 @@ func (repo UserRepository) UpdateUser(userID int, updated *model.User) (*model.U
 	return &user, nil
 }
 
-func (repo UserRepository) DeleteUser(user *model.User) error {
-	tx := repo.db.Delete(&model.User{}, user)
+// FindUsers returns a page of users in the order they signed up, only those
+// with the role unless it's empty.
+func (repo UserRepository) FindUsers(role string, limit, offset int) ([]*model.User, error) {
+	var users []*model.User
+	tx := repo.filterUsers(role).Order("id").Limit(limit).Offset(offset).Find(&users)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return users, nil
+}
+
+func (repo UserRepository) CountUsers(role string) (int64, error) {
+	var count int64
+	tx := repo.filterUsers(role).Model(&model.User{}).Count(&count)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return count, nil
+}
+
+func (repo UserRepository) filterUsers(role string) *gorm.DB {
+	if role == "" {
+		return repo.db
+	}
+	return repo.db.Where("role = ?", role)
+}
+
+func (repo UserRepository) SetUserRole(userID int, role string) error {
+	return repo.setUserColumn(userID, "role", role)
+}
+
+// SetUserSuspended suspends the user, or lifts the suspension when
+// suspendedAt is nil.
+func (repo UserRepository) SetUserSuspended(userID int, suspendedAt *time.Time) error {
+	return repo.setUserColumn(userID, "suspended_at", suspendedAt)
+}
+
+func (repo UserRepository) SetUserPassword(userID int, passwordHash string) error {
+	return repo.setUserColumn(userID, "password", passwordHash)
+}
+
+// VerifyUser marks the email address of the user as confirmed, keeping the
+// time it was first confirmed.
+func (repo UserRepository) VerifyUser(userID int) error {
+	tx := repo.db.Model(&model.User{}).Where("id = ? AND verified_at IS NULL", userID).Update("verified_at", time.Now())
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// ReserveVerificationEmail records that a verification email is being sent to
+// the user, unless one was already sent since the given time. It reports
+// whether the email may be sent.
+func (repo UserRepository) ReserveVerificationEmail(userID int, since time.Time) (bool, error) {
+	tx := repo.db.Model(&model.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)", userID, since).
+		Update("verification_sent_at", time.Now())
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 1, nil
+}
+
+func (repo UserRepository) setUserColumn(userID int, column string, value interface{}) error {
+	tx := repo.db.Model(&model.User{}).Where("id = ?", userID).Update(column, value)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteUser deletes the user along with their posts. Both are kept until
+// PurgeDeletedUsers, and RestoreUser brings them back meanwhile. With suspend,
+// the user is suspended in the same transaction unless they already are.
+func (repo UserRepository) DeleteUser(user *model.User, suspend bool) error {
+	now := time.Now()
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		if suspend {
+			tx := db.Model(&model.User{}).Where("id = ? AND suspended_at IS NULL", user.ID).UpdateColumn("suspended_at", now)
+			if tx.Error != nil {
+				return tx.Error
+			}
+		}
+
+		tx := db.Model(&model.User{}).Where("id = ?", user.ID).UpdateColumn("deleted_at", now)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		// posts deleted before keep the time they were deleted, which tells
+		// them apart from the posts deleted with the user
+		tx = db.Model(&model.Post{}).Where("user_id = ?", user.ID).UpdateColumn("deleted_at", now)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		return nil
+	})
+}
+
+// FindDeletedUser finds a user deleted since the given time.
+func (repo UserRepository) FindDeletedUser(userID int, since time.Time) (*model.User, error) {
+	var user model.User
+	tx := repo.db.Unscoped().First(&user, "id = ? AND deleted_at >= ?", userID, since)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &user, nil
+}
+
+func (repo UserRepository) FindDeletedUserByEmail(email string, since time.Time) (*model.User, error) {
+	var user model.User
+	tx := repo.db.Unscoped().First(&user, "email = ? AND deleted_at >= ?", email, since)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return &user, nil
+}
+
+// RestoreUser brings back a deleted user along with the posts deleted with
+// them.
+func (repo UserRepository) RestoreUser(userID int) error {
+	return repo.db.Transaction(func(db *gorm.DB) error {
+		var user model.User
+		tx := db.Unscoped().First(&user, "id = ? AND deleted_at IS NOT NULL", userID)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		tx = db.Unscoped().Model(&model.User{}).Where("id = ?", userID).UpdateColumn("deleted_at", nil)
+		if tx.Error != nil {
+			return tx.Error
+		}
+
+		tx = db.Unscoped().Model(&model.Post{}).Where("user_id = ? AND deleted_at = ?", userID, user.DeletedAt.Time).UpdateColumn("deleted_at", nil)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		return nil
+	})
+}
+
+// PurgeDeletedUsers permanently deletes the users deleted before the given
+// time, with their posts, comments and credentials, and returns how many
+// there were.
+func (repo UserRepository) PurgeDeletedUsers(before time.Time) (int64, error) {
+	var ids []uint
+	tx := repo.db.Unscoped().Model(&model.User{}).Where("deleted_at < ?", before).Pluck("id", &ids)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	var n int64
+	for _, id := range ids {
+		if err := repo.db.Transaction(func(db *gorm.DB) error { return purgeUser(db, id) }); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func purgeUser(db *gorm.DB, userID uint) error {
+	// the user's posts with the comments on them, then the user's own
+	// comments on other posts along with the replies to them
+	posts := db.Unscoped().Model(&model.Post{}).Select("id").Where("user_id = ?", userID)
+	if err := purgePosts(db, posts); err != nil {
+		return err
+	}
+
+	tx := deleteCommentThreads(db, "user_id = ?", userID)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("user_id = ?", userID).Delete(&model.PasswordReset{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("user_id = ?", userID).Delete(&model.RecoveryCode{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("user_id = ?", userID).Delete(&model.LoginChallenge{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("user_id = ?", userID).Delete(&model.Identity{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	tx = db.Where("user_id = ?", userID).Delete(&model.APIKey{})
 	if tx.Error != nil {
 		return tx.Error
 	}
 
-	tx = repo.db.Where("user_id = ?", user.ID).Delete(&model.Post{})
+	tx = db.Unscoped().Delete(&model.User{}, "id = ?", userID)
 	if tx.Error != nil {
 		return tx.Error
 	}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+func (s *Server) RegisterAdminRoutes(g *echo.Group) {
+	users := g.Group("/admin/users")
+	users.Use(s.AuthenticateUser, s.RequirePermission(model.PermissionManageUsers))
+	users.GET("", s.handleListUsers)
+	users.PUT("/:id/role", s.handleChangeUserRole)
+	users.POST("/:id/suspend", s.handleSuspendUser)
+	users.POST("/:id/unsuspend", s.handleUnsuspendUser)
+	users.DELETE("/:id", s.handleDeleteUser)
+
+	posts := g.Group("/admin/posts")
+	posts.Use(s.AuthenticateUser)
+	posts.POST("/:id/unpublish", s.handleUnpublishPost, s.RequirePermission(model.PermissionUnpublishPost))
+	posts.POST("/:id/restore", s.handleRestorePost, s.RequirePermission(model.PermissionEditAnyPost))
+}
+
+// handleListUsers pages through users in the order they signed up, ?role=
+// only listing users with that role.
+func (s *Server) handleListUsers(c echo.Context) error {
+	page, limit := pageParams(c)
+	role := c.QueryParams().Get("role")
+	if role != "" && !model.IsRole(role) {
+		return RespondWithError(c, http.StatusBadRequest, "role must be user, editor or admin")
+	}
+
+	total, err := s.userStore.CountUsers(role)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	users, err := s.userStore.FindUsers(role, limit, (page-1)*limit)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	data := make([]model.UserResponse, 0, len(users))
+	for _, u := range users {
+		data = append(data, model.NewUserResponse(u))
+	}
+	return RespondWithList(c, pageList(c, data, total, page, limit))
+}
+
+func (s *Server) handleChangeUserRole(c echo.Context) error {
+	userID, code, err := s.adminTarget(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	r := new(model.RoleRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.userStore.SetUserRole(userID, r.Role); err != nil {
+		return respondWithUserError(c, err)
+	}
+
+	return s.respondWithUser(c, userID)
+}
+
+// handleSuspendUser keeps the user from logging in and signs them out
+// everywhere. Their posts and comments stay.
+func (s *Server) handleSuspendUser(c echo.Context) error {
+	userID, code, err := s.adminTarget(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	now := time.Now()
+	if err := s.userStore.SetUserSuspended(userID, &now); err != nil {
+		return respondWithUserError(c, err)
+	}
+
+	if err := s.sessionStore.RevokeUserSessions(uint(userID)); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return s.respondWithUser(c, userID)
+}
+
+func (s *Server) handleUnsuspendUser(c echo.Context) error {
+	userID, code, err := s.adminTarget(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	if err := s.userStore.SetUserSuspended(userID, nil); err != nil {
+		return respondWithUserError(c, err)
+	}
+
+	return s.respondWithUser(c, userID)
+}
+
+// handleDeleteUser deletes the user along with their content, like users
+// deleting their own profile. The user is suspended too, so they can't
+// restore their account by logging in.
+func (s *Server) handleDeleteUser(c echo.Context) error {
+	userID, code, err := s.adminTarget(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	user, err := s.userStore.FindUser(userID)
+	if err != nil {
+		return respondWithUserError(c, err)
+	}
+
+	if err := s.sessionStore.RevokeUserSessions(user.ID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := s.userStore.DeleteUser(user, true); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusNoContent, nil)
+}
+
+// handleUnpublishPost turns a published post back into a draft, keeping it
+// from readers until its author publishes it again.
+func (s *Server) handleUnpublishPost(c echo.Context) error {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
+	}
+
+	var post *model.Post
+	post, err = s.postStore.FindPost(post, postID)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	if post.Status == model.PostStatusDraft {
+		s.renderPost(post)
+		return RespondWithJSON(c, http.StatusOK, post)
+	}
+
+	updated, err := s.postStore.UpdatePost(post, &model.Post{
+		Status:    model.PostStatusDraft,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	s.renderPost(updated)
+	return RespondWithJSON(c, http.StatusOK, updated)
+}
+
+// handleRestorePost brings back a deleted post with its comments, tags and
+// history. The posts of deleted users come back when the user is restored.
+func (s *Server) handleRestorePost(c echo.Context) error {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
+	}
+
+	if err := s.postStore.RestorePost(postID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return RespondWithError(c, http.StatusNotFound, "No deleted post with this id, or its author is deleted too")
+		}
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	var post *model.Post
+	post, err = s.postStore.FindPost(post, postID)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	s.renderPost(post)
+	return RespondWithJSON(c, http.StatusOK, post)
+}
+
+// adminTarget reads the ID of the user an admin acts on. Admins can't act on
+// themselves, so there is always an admin left.
+func (s *Server) adminTarget(c echo.Context) (int, int, error) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, http.StatusBadRequest, errors.New("Provide userid")
+	}
+
+	if self, ok := c.Get("userID").(int); ok && self == userID {
+		return 0, http.StatusBadRequest, errors.New("Admins can't change their own account here")
+	}
+	return userID, http.StatusOK, nil
+}
+
+func (s *Server) respondWithUser(c echo.Context, userID int) error {
+	user, err := s.userStore.FindUser(userID)
+	if err != nil {
+		return respondWithUserError(c, err)
+	}
+	return RespondWithJSON(c, http.StatusOK, model.NewUserResponse(user))
+}
+
+func respondWithUserError(c echo.Context, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return RespondWithError(c, http.StatusNotFound, "not existing/valid user id")
+	}
+	return RespondWithError(c, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix tells API keys apart from access tokens in the
+	// Authorization header, and makes them easy to find in leaked code
+	apiKeyPrefix = "blog_"
+	// apiKeyDisplayLength is how much of a key is kept to show in the list
+	apiKeyDisplayLength = 12
+	maxAPIKeys          = 20
+)
+
+// allowAPIKey lets API keys with the scope use the route. Routes are closed
+// to API keys unless they are allowed here.
+func (s *Server) allowAPIKey(route *echo.Route, scope string) {
+	s.apiKeyScopes[route.Method+" "+route.Path] = scope
+}
+
+// findAPIKey looks up the API key of the request once, as the rate limiter
+// asks for it before AuthenticateUser.
+func (s *Server) findAPIKey(c echo.Context, key string) (*model.APIKey, error) {
+	if apiKey, ok := c.Get("apiKey").(*model.APIKey); ok {
+		return apiKey, nil
+	}
+
+	apiKey, err := s.authStore.FindAPIKey(hashToken(key))
+	if err != nil {
+		return nil, err
+	}
+	c.Set("apiKey", apiKey)
+	return apiKey, nil
+}
+
+// authenticateAPIKey is the part of AuthenticateUser for requests sending an
+// API key rather than an access token.
+func (s *Server) authenticateAPIKey(c echo.Context, key string) error {
+	apiKey, err := s.findAPIKey(c, key)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid API key")
+	}
+	now := time.Now()
+	if apiKey.IsExpired(now) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "The API key has expired")
+	}
+
+	scope, ok := s.apiKeyScopes[c.Request().Method+" "+c.Path()]
+	if !ok {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys can't be used for this resource.")
+	}
+	if !apiKey.HasScope(scope) {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("The API key doesn't have the %s scope.", scope))
+	}
+
+	user, err := s.userStore.FindUser(int(apiKey.UserID))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid API key")
+	}
+	if user.IsSuspended() {
+		return echo.NewHTTPError(http.StatusForbidden, "Your account has been suspended")
+	}
+
+	if err := s.authStore.TouchAPIKey(apiKey.ID, now); err != nil {
+		log.Printf("[apikey] error: %s", err)
+	}
+
+	c.Set("userID", int(user.ID))
+	c.Set("user", user)
+	return nil
+}
+
+func (s *Server) handleListAPIKeys(c echo.Context) error {
+	userID, ok := c.Get("userID").(int)
+	if !ok {
+		return RespondWithError(c, http.StatusInternalServerError, "User ID not found in context")
+	}
+
+	page, limit := pageParams(c)
+	total, err := s.authStore.CountAPIKeys(uint(userID))
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	keys, err := s.authStore.FindAPIKeys(uint(userID), limit, (page-1)*limit)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	data := make([]model.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		data = append(data, model.NewAPIKeyResponse(key))
+	}
+	return RespondWithList(c, pageList(c, data, total, page, limit))
+}
+
+// handleCreateAPIKey returns the new key, which can't be shown again later.
+// The current password is asked for, as a key outlives the session that
+// created it.
+func (s *Server) handleCreateAPIKey(c echo.Context) error {
+	r := new(model.CreateAPIKeyRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	user, err := s.reauthenticate(c, r.CurrentPassword)
+	if err != nil {
+		return respondWithReauthError(c, err)
+	}
+
+	count, err := s.authStore.CountAPIKeys(user.ID)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if count >= maxAPIKeys {
+		return RespondWithError(c, http.StatusConflict, fmt.Sprintf("You can have at most %d API keys, revoke one first", maxAPIKeys))
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	key := apiKeyPrefix + token
+
+	var scopes []string
+	for _, scope := range r.Scopes {
+		if !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	apiKey := &model.APIKey{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(r.Name),
+		Prefix:    key[:apiKeyDisplayLength],
+		KeyHash:   hashToken(key),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: r.ExpiresAt,
+	}
+	if err := s.authStore.CreateAPIKey(apiKey); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusCreated, model.CreatedAPIKeyResponse{APIKeyResponse: model.NewAPIKeyResponse(apiKey), Key: key})
+}
+
+// handleRevokeAPIKey deletes a key, which stops working right away.
+func (s *Server) handleRevokeAPIKey(c echo.Context) error {
+	userID, ok := c.Get("userID").(int)
+	if !ok {
+		return RespondWithError(c, http.StatusInternalServerError, "User ID not found in context")
+	}
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide API key id")
+	}
+
+	if err := s.authStore.DeleteAPIKey(uint(userID), uint(keyID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return RespondWithError(c, http.StatusNotFound, "API key not found")
+		}
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusNoContent, nil)
+}
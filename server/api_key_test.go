@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	cred := &model.LoginRequest{Email: "scripted@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "scripted", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+
+	createKey := func(r model.CreateAPIKeyRequest) (int, model.CreatedAPIKeyResponse) {
+		c, resp := makeRequest("POST", "/v1/user/api-keys", r, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleCreateAPIKey)(c))
+		var created model.CreatedAPIKeyResponse
+		if resp.Code == http.StatusCreated {
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+		}
+		return resp.Code, created
+	}
+
+	// request calls the handler with the key, as routed to the path
+	request := func(method, path, key string, handler echo.HandlerFunc) int {
+		c, resp := makeRequest(method, path, nil, false, nil)
+		c.SetPath(path)
+		c.Request().Header.Set(echo.HeaderAuthorization, "Bearer "+key)
+		err := srv.AuthenticateUser(handler)(c)
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he.Code
+		}
+		require.NoError(t, err)
+		return resp.Code
+	}
+
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name         string
+		request      model.CreateAPIKeyRequest
+		expectedCode int
+	}{
+		{name: "no name", request: model.CreateAPIKeyRequest{Scopes: []string{model.ScopeProfile}, CurrentPassword: cred.Password}, expectedCode: http.StatusBadRequest},
+		{name: "no scopes", request: model.CreateAPIKeyRequest{Name: "script", CurrentPassword: cred.Password}, expectedCode: http.StatusBadRequest},
+		{name: "unknown scope", request: model.CreateAPIKeyRequest{Name: "script", Scopes: []string{"users:manage"}, CurrentPassword: cred.Password}, expectedCode: http.StatusBadRequest},
+		{name: "expired", request: model.CreateAPIKeyRequest{Name: "script", Scopes: []string{model.ScopeProfile}, ExpiresAt: &past, CurrentPassword: cred.Password}, expectedCode: http.StatusBadRequest},
+		{name: "no password", request: model.CreateAPIKeyRequest{Name: "script", Scopes: []string{model.ScopeProfile}}, expectedCode: http.StatusBadRequest},
+		{name: "wrong password", request: model.CreateAPIKeyRequest{Name: "script", Scopes: []string{model.ScopeProfile}, CurrentPassword: "87654321"}, expectedCode: http.StatusForbidden},
+		{name: "valid", request: model.CreateAPIKeyRequest{Name: "script", Scopes: []string{model.ScopeProfile, model.ScopePostsRead, model.ScopeProfile}, CurrentPassword: cred.Password}, expectedCode: http.StatusCreated},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, _ := createKey(tc.request)
+			assert.Equal(t, tc.expectedCode, code)
+		})
+	}
+
+	code, created := createKey(model.CreateAPIKeyRequest{Name: "reader", Scopes: []string{model.ScopePostsRead}, CurrentPassword: cred.Password})
+	require.Equal(t, http.StatusCreated, code)
+	assert.True(t, strings.HasPrefix(created.Key, apiKeyPrefix))
+	assert.Equal(t, created.Key[:apiKeyDisplayLength], created.Prefix)
+	assert.Equal(t, []string{model.ScopePostsRead}, created.Scopes)
+	assert.Nil(t, created.LastUsedAt)
+
+	t.Run("scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request("GET", "/v1/posts/", created.Key, srv.handleExplorePosts))
+		assert.Equal(t, http.StatusForbidden, request("GET", "/v1/user/me", created.Key, srv.handleGetMe), "missing scope")
+		assert.Equal(t, http.StatusForbidden, request("POST", "/v1/posts/", created.Key, srv.handleCreatePost), "missing scope")
+		assert.Equal(t, http.StatusForbidden, request("PUT", "/v1/user/password", created.Key, srv.handleChangePassword), "not for API keys")
+		assert.Equal(t, http.StatusForbidden, request("POST", "/v1/user/api-keys", created.Key, srv.handleCreateAPIKey), "not for API keys")
+		assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/posts/", apiKeyPrefix+"unknown", srv.handleExplorePosts))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		c, resp := makeRequest("GET", "/v1/user/api-keys", nil, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleListAPIKeys)(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), created.Key)
+
+		var list model.ListResponse[model.APIKeyResponse]
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+		assert.Equal(t, int64(2), list.Total)
+		require.Len(t, list.Data, 2)
+		assert.Equal(t, created.ID, list.Data[0].ID)
+		assert.NotNil(t, list.Data[0].LastUsedAt)
+		assert.Equal(t, []string{model.ScopeProfile, model.ScopePostsRead}, list.Data[1].Scopes)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var u *model.User
+		u, err := srv.authStore.FindUser(u, cred.Email)
+		require.NoError(t, err)
+		key := apiKeyPrefix + "expired"
+		require.NoError(t, srv.authStore.CreateAPIKey(&model.APIKey{UserID: u.ID, Name: "old", Prefix: key, KeyHash: hashToken(key), Scopes: model.ScopePostsRead, ExpiresAt: &past}))
+		assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/posts/", key, srv.handleExplorePosts))
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		path := "/v1/user/api-keys/" + strconv.Itoa(int(created.ID))
+		c, resp := makeRequest("DELETE", path, nil, true, cred)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(int(created.ID)))
+		require.NoError(t, srv.AuthenticateUser(srv.handleRevokeAPIKey)(c))
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/posts/", created.Key, srv.handleExplorePosts))
+
+		c, resp = makeRequest("DELETE", path, nil, true, cred)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(int(created.ID)))
+		require.NoError(t, srv.AuthenticateUser(srv.handleRevokeAPIKey)(c))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("changing the password revokes every key", func(t *testing.T) {
+		code, created := createKey(model.CreateAPIKeyRequest{Name: "reader", Scopes: []string{model.ScopePostsRead}, CurrentPassword: cred.Password})
+		require.Equal(t, http.StatusCreated, code)
+		require.Equal(t, http.StatusOK, request("GET", "/v1/posts/", created.Key, srv.handleExplorePosts))
+
+		c, resp := makeRequest("PUT", "/v1/user/password", &model.ChangePasswordRequest{CurrentPassword: cred.Password, Password: "87654321", PasswordConfirm: "87654321"}, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleChangePassword)(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+		cred.Password = "87654321"
+
+		assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/posts/", created.Key, srv.handleExplorePosts))
+	})
+}
@@ -1,20 +1,33 @@
 package server
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func (s *Server) RegisterAuthRoutes(g *echo.Group) {
 	router := g.Group("/auth")
 	router.POST("/register", s.handleRegister)
 	router.POST("/login", s.handleLogin)
-	router.GET("/logout", s.handleLogout, AuthenticateUser)
+	router.POST("/refresh", s.handleRefresh)
+	router.GET("/verify", s.handleVerifyEmail)
+	router.POST("/verify/resend", s.handleResendVerification, s.AuthenticateUser)
+	router.GET("/confirm-email", s.handleConfirmEmail)
+	router.POST("/2fa", s.handleTwoFactorLogin)
+	router.GET("/oidc/:provider", s.handleOIDCLogin)
+	router.GET("/oidc/:provider/callback", s.handleOIDCCallback)
+	router.POST("/forgot-password", s.handleForgotPassword)
+	router.GET("/reset-password", s.handleCheckPasswordReset)
+	router.POST("/reset-password", s.handleResetPassword)
+	router.POST("/logout", s.handleLogout, s.AuthenticateUser)
 }
 
 func (s *Server) handleRegister(c echo.Context) error {
@@ -27,6 +40,18 @@ func (s *Server) handleRegister(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
+	// deleted users keep their address until they are purged
+	taken, err := s.authStore.EmailTaken(r.Email)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if taken {
+		if _, err := s.userStore.FindDeletedUserByEmail(r.Email, time.Time{}); err == nil {
+			return RespondWithError(c, http.StatusConflict, "The account with this email address was deleted, log in to restore it")
+		}
+		return RespondWithError(c, http.StatusConflict, "The email address is already in use")
+	}
+
 	// hash pwd
 	hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), 10)
 	if err != nil {
@@ -34,17 +59,25 @@ func (s *Server) handleRegister(c echo.Context) error {
 	}
 
 	// create a User from registerRequest data
+	now := time.Now()
 	u := model.User{
-		Name:      r.Name,
-		Email:     r.Email,
-		Password:  string(hash),
-		CreatedAt: time.Now(),
+		Name:               r.Name,
+		Email:              r.Email,
+		Password:           string(hash),
+		CreatedAt:          now,
+		Role:               model.RoleUser,
+		VerificationSentAt: &now,
 	}
 
 	if err = s.authStore.CreateUser(&u); err != nil {
 		return RespondWithError(c, http.StatusInternalServerError, err.Error())
 	}
 
+	// the account exists either way, users can ask for another email
+	if err := s.sendVerificationEmail(&u); err != nil {
+		log.Printf("failed to send verification email to user %d: %s", u.ID, err)
+	}
+
 	return RespondWithJSON(c, http.StatusCreated, "success")
 }
 
@@ -58,45 +91,171 @@ func (s *Server) handleLogin(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
+	// locked out clients are refused before the password is checked, so
+	// guessing it right doesn't help them either
+	ipKey, accountKey := s.loginKeys(c, r.Email)
+	wait, err := s.loginLockout(ipKey, accountKey)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if wait > 0 {
+		return respondWithLockout(c, wait)
+	}
+
 	var u *model.User
 	// query db with email
-	u, err := s.authStore.FindUser(u, r.Email)
+	u, err = s.authStore.FindUser(u, r.Email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// deleted accounts can still log in, which restores them
+		u, err = s.userStore.FindDeletedUserByEmail(r.Email, time.Now().Add(-restoreWindow))
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	// check if pwd of loginRequest match with real pwd. Unknown emails and
+	// users without a password are compared against a dummy hash, so they
+	// can't be told apart by the response or its timing
+	hash := dummyPasswordHash()
+	if u != nil && u.Password != "" {
+		hash = []byte(u.Password)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(r.Password)); err != nil || u == nil || u.Password == "" {
+		if err := s.failLogin(ipKey, accountKey); err != nil {
+			return RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return RespondWithError(c, http.StatusUnauthorized, "Invalid email or password")
+	}
+
+	if u.IsSuspended() {
+		return RespondWithError(c, http.StatusForbidden, "Your account has been suspended")
+	}
+
+	// the session starts once the second factor is checked too
+	if u.HasTwoFactor() {
+		return s.startLoginChallenge(c, u, r.ReturnToken)
+	}
+
+	return s.startSession(c, u, r.ReturnToken)
+}
+
+// startSession logs the user in, sending the tokens in the body or in
+// cookies. A deleted account is restored first.
+func (s *Server) startSession(c echo.Context, user *model.User, returnToken bool) error {
+	if user.DeletedAt.Valid {
+		if err := s.userStore.RestoreUser(int(user.ID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return RespondWithError(c, http.StatusUnauthorized, "This account no longer exists")
+			}
+			return RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	access, refresh, err := s.newSession(user.ID)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	// failures of the account are forgotten once it's logged in to, with the
+	// second factor if it has one. The address stays counted, or attackers
+	// could log in to an account of their own between guesses to keep going
+	_, accountKey := s.loginKeys(c, user.Email)
+	if err := s.loginAttempts.ResetLogin(accountKey.key); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if returnToken {
+		return RespondWithJSON(c, http.StatusOK, tokenResponse(access, refresh))
+	}
+
+	if err := s.setSessionCookies(c, access, refresh); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}
+
+func (s *Server) handleRefresh(c echo.Context) error {
+	r := new(model.RefreshRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	// clients that got their tokens in the body send the refresh token
+	// the same way, browsers send the cookie
+	fromCookie := false
+	if cookie, err := c.Cookie("refresh-token"); err == nil && r.RefreshToken == "" {
+		r.RefreshToken = cookie.Value
+		fromCookie = true
+	}
+	if r.RefreshToken == "" {
+		return RespondWithError(c, http.StatusUnauthorized, "Refresh token is missing")
+	}
+	if fromCookie && !validCSRF(c) {
+		return RespondWithError(c, http.StatusForbidden, "Invalid CSRF token")
+	}
+
+	session, err := s.sessionStore.FindSession(hashToken(r.RefreshToken))
+	if err != nil {
+		return RespondWithError(c, http.StatusUnauthorized, "Invalid refresh token")
+	}
+
+	// a used or revoked token means it was stolen or replayed, so the
+	// whole session family is revoked
+	if session.UsedAt != nil || session.RevokedAt != nil {
+		if err := s.sessionStore.RevokeFamily(session.FamilyID); err != nil {
+			return RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		s.clearSessionCookies(c)
+		return RespondWithError(c, http.StatusUnauthorized, "Refresh token reuse detected")
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return RespondWithError(c, http.StatusUnauthorized, "Refresh token has expired")
+	}
+
+	refresh, next, err := newRefreshSession(session.UserID, session.FamilyID)
 	if err != nil {
 		return RespondWithError(c, http.StatusInternalServerError, err.Error())
 	}
 
-	// check if pwd of loginRequest match with real pwd
-	if err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(r.Password)); err != nil {
-		return RespondWithError(c, http.StatusBadRequest, "Invalid login credientials!")
+	if err := s.sessionStore.RotateSession(session, next); err != nil {
+		if errors.Is(err, repository.ErrSessionReused) {
+			if err := s.sessionStore.RevokeFamily(session.FamilyID); err != nil {
+				return RespondWithError(c, http.StatusInternalServerError, err.Error())
+			}
+			s.clearSessionCookies(c)
+			return RespondWithError(c, http.StatusUnauthorized, "Refresh token reuse detected")
+		}
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
 	}
 
-	// create a token
-	token, err := CreateToken(u.ID, time.Hour, os.Getenv("JWT_SECRET"))
+	access, err := s.keys.CreateToken(session.UserID, session.FamilyID, accessTokenTTL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if !fromCookie {
+		return RespondWithJSON(c, http.StatusOK, tokenResponse(access, refresh))
 	}
 
-	cookie := http.Cookie{
-		Name:     "access-token",
-		Value:    token,
-		Expires:  time.Now().Add(time.Hour).UTC(),
-		HttpOnly: true,
-		Path:     "/",
+	if err := s.setSessionCookies(c, access, refresh); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
 	}
-	c.SetCookie(&cookie)
 
 	return RespondWithJSON(c, http.StatusOK, "success")
 }
 
 func (s *Server) handleLogout(c echo.Context) error {
-	cookie := http.Cookie{
-		Name:     "access-token",
-		Value:    "",
-		Expires:  time.Now().Add(-time.Hour).UTC(),
-		HttpOnly: true,
-		Path:     "/",
-	}
-	c.SetCookie(&cookie)
+	sessionID, ok := c.Get("sessionID").(string)
+	if !ok {
+		return RespondWithError(c, http.StatusInternalServerError, "Session ID not found in context")
+	}
+
+	if err := s.sessionStore.RevokeFamily(sessionID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	s.clearSessionCookies(c)
 
 	return RespondWithJSON(c, http.StatusOK, "logout successful")
 }
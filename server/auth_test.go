@@ -1,12 +1,15 @@
 package server
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandleRegister(t *testing.T) {
@@ -102,7 +105,7 @@ func TestHandleRegister(t *testing.T) {
 			authReq:       false,
 			cred:          nil,
 			expectedError: false,
-			expectedCode:  http.StatusInternalServerError,
+			expectedCode:  http.StatusConflict,
 		},
 	}
 
@@ -115,6 +118,72 @@ func TestHandleRegister(t *testing.T) {
 	}
 }
 
+func TestVerifyEmail(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+
+	// unverified users can log in but not post
+	c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Unverified", Content: "Unverified"}, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// an email was just sent at registration
+	c, resp = makeRequest("POST", "/v1/auth/verify/resend", nil, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleResendVerification)(c))
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	access := bearerToken(john).Value
+	tests := []struct {
+		name         string
+		token        string
+		expectedCode int
+	}{
+		{
+			name:         "missing token",
+			token:        "",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "tampered token",
+			token:        lastMailToken(t, john.Email, "verify") + "x",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "access tokens aren't verification tokens",
+			token:        access,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "token from the email",
+			token:        lastMailToken(t, john.Email, "verify"),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "links can be opened twice",
+			token:        lastMailToken(t, john.Email, "verify"),
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, resp := makeRequest("GET", "/v1/auth/verify?token="+tc.token, nil, false, nil)
+			require.NoError(t, srv.handleVerifyEmail(c))
+			assert.Equal(t, tc.expectedCode, resp.Code)
+		})
+	}
+
+	c, resp = makeRequest("GET", "/v1/user/me", nil, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleGetMe)(c))
+	var me model.UserResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &me))
+	assert.NotNil(t, me.VerifiedAt)
+
+	c, resp = makeRequest("POST", "/v1/auth/verify/resend", nil, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleResendVerification)(c))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
 func TestHandleLogin(t *testing.T) {
 
 	tests := []struct {
@@ -197,7 +266,20 @@ func TestHandleLogin(t *testing.T) {
 			authReq:       false,
 			cred:          nil,
 			expectedError: false,
-			expectedCode:  http.StatusBadRequest,
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			// unknown email
+			method: "POST",
+			route:  "/v1/auth/login",
+			body: model.LoginRequest{
+				Email:    "nobody@gmail.com",
+				Password: "12345678",
+			},
+			authReq:       false,
+			cred:          nil,
+			expectedError: false,
+			expectedCode:  http.StatusUnauthorized,
 		},
 		{
 			// successful
@@ -236,7 +318,7 @@ func TestHandleLogout(t *testing.T) {
 	}{
 		{
 			// missing auth token
-			method:            "GET",
+			method:            "POST",
 			route:             "/v1/auth/logout",
 			body:              nil,
 			authReq:           false,
@@ -247,7 +329,7 @@ func TestHandleLogout(t *testing.T) {
 		},
 		{
 			// success
-			method:            "GET",
+			method:            "POST",
 			route:             "/v1/auth/logout",
 			body:              nil,
 			authReq:           true,
@@ -262,7 +344,7 @@ func TestHandleLogout(t *testing.T) {
 		c, resp := makeRequest(test.method, test.route, test.body, test.authReq, test.cred)
 
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleLogout)(c)
+			err := srv.AuthenticateUser(srv.handleLogout)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -275,8 +357,122 @@ func TestHandleLogout(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleLogout)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleLogout)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 	}
 }
+
+func TestHandleRefresh(t *testing.T) {
+	c, resp := makeRequest("POST", "/v1/auth/login", model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}, false, nil)
+	require.NoError(t, srv.handleLogin(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	refreshCookie := func(resp *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range resp.Result().Cookies() {
+			if cookie.Name == "refresh-token" {
+				return cookie
+			}
+		}
+		return nil
+	}
+	first := refreshCookie(resp)
+	require.NotNil(t, first)
+
+	// missing refresh token
+	c, resp = makeRequest("POST", "/v1/auth/refresh", nil, false, nil)
+	if assert.NoError(t, srv.handleRefresh(c)) {
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	// cookie without CSRF token
+	c, resp = makeRequest("POST", "/v1/auth/refresh", nil, false, nil)
+	c.Request().AddCookie(first)
+	if assert.NoError(t, srv.handleRefresh(c)) {
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	}
+
+	// successful rotation
+	c, resp = makeRequest("POST", "/v1/auth/refresh", nil, false, nil)
+	c.Request().AddCookie(first)
+	withCSRF(c.Request())
+	if assert.NoError(t, srv.handleRefresh(c)) {
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+	second := refreshCookie(resp)
+	require.NotNil(t, second)
+	assert.NotEqual(t, first.Value, second.Value)
+
+	// reusing the rotated token revokes the family
+	c, resp = makeRequest("POST", "/v1/auth/refresh", nil, false, nil)
+	c.Request().AddCookie(first)
+	withCSRF(c.Request())
+	if assert.NoError(t, srv.handleRefresh(c)) {
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+
+	// so the latest token is rejected as well
+	c, resp = makeRequest("POST", "/v1/auth/refresh", model.RefreshRequest{RefreshToken: second.Value}, false, nil)
+	if assert.NoError(t, srv.handleRefresh(c)) {
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	cred := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	token := bearerToken(cred)
+
+	// logging out changes state, so it needs the CSRF token like the rest
+	c, _ := makeRequest("POST", "/v1/auth/logout", nil, false, nil)
+	c.Request().AddCookie(token)
+	err := srv.AuthenticateUser(srv.handleLogout)(c)
+	if he, ok := err.(*echo.HTTPError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusForbidden, he.Code)
+	}
+
+	c, resp := makeRequest("POST", "/v1/auth/logout", nil, false, nil)
+	c.Request().AddCookie(token)
+	withCSRF(c.Request())
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleLogout)(c)) {
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+
+	c, _ = makeRequest("POST", "/v1/auth/logout", nil, false, nil)
+	c.Request().AddCookie(token)
+	withCSRF(c.Request())
+	err = srv.AuthenticateUser(srv.handleLogout)(c)
+	he, ok := err.(*echo.HTTPError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusUnauthorized, he.Code)
+	}
+}
+
+func TestBearerAuthentication(t *testing.T) {
+	c, resp := makeRequest("POST", "/v1/auth/login", model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678", ReturnToken: true}, false, nil)
+	require.NoError(t, srv.handleLogin(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Result().Cookies())
+
+	var tokens model.TokenResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &tokens))
+	require.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, "Bearer", tokens.TokenType)
+
+	// unsafe methods don't need a CSRF token with the Authorization header
+	c, resp = makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Bearer", Content: "Posted with a bearer token"}, false, nil)
+	c.Request().Header.Set(echo.HeaderAuthorization, "Bearer "+tokens.AccessToken)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c)) {
+		assert.Equal(t, http.StatusCreated, resp.Code)
+	}
+
+	// but do with the cookie
+	c, _ = makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Cookie", Content: "Posted with a cookie"}, false, nil)
+	c.Request().AddCookie(&http.Cookie{Name: "access-token", Value: tokens.AccessToken})
+	err := srv.AuthenticateUser(srv.handleCreatePost)(c)
+	he, ok := err.(*echo.HTTPError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusForbidden, he.Code)
+		assert.Equal(t, "Invalid CSRF token", he.Message)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/labstack/echo"
+)
+
+var errForbidden = errors.New("you are not allowed to modify this resource")
+
+// Owned is implemented by every resource that belongs to a user.
+type Owned interface {
+	OwnerID() uint
+}
+
+// OwnershipBypass reports whether the user of the request may act on a
+// resource owned by someone else, e.g. because they are an admin.
+type OwnershipBypass func(c echo.Context, resource Owned) bool
+
+// AllowOwnershipBypass registers an exception to the ownership check done by
+// authorize.
+func (s *Server) AllowOwnershipBypass(bypass OwnershipBypass) {
+	s.bypasses = append(s.bypasses, bypass)
+}
+
+// authorize checks that the authenticated user owns at least one of the given
+// resources or is allowed through by a registered bypass.
+func (s *Server) authorize(c echo.Context, resources ...Owned) error {
+	userID, ok := c.Get("userID").(int)
+	if !ok {
+		return errForbidden
+	}
+
+	for _, r := range resources {
+		if r.OwnerID() == uint(userID) {
+			return nil
+		}
+	}
+
+	for _, r := range resources {
+		for _, bypass := range s.bypasses {
+			if bypass(c, r) {
+				return nil
+			}
+		}
+	}
+
+	return errForbidden
+}
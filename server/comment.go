@@ -0,0 +1,207 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+)
+
+func (s *Server) RegisterCommentRoutes(g *echo.Group) {
+	router := g.Group("/posts/:id/comments")
+	router.Use(s.AuthenticateUser)
+	router.POST("", s.handleCreateComment)
+	router.GET("", s.handleListComments)
+	router.PUT("/:commentID", s.handleUpdateComment)
+	router.DELETE("/:commentID", s.handleDeleteComment)
+}
+
+func (s *Server) handleCreateComment(c echo.Context) error {
+	userID, ok := c.Get("userID").(int)
+	if !ok {
+		return RespondWithError(c, http.StatusInternalServerError, "User ID not found in context")
+	}
+
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
+	}
+
+	if _, err = s.findVisiblePost(c, postID); err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	r := new(model.CreateCommentRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	// replies must stay within the thread of the same post
+	if r.ParentID != nil {
+		if _, err := s.commentStore.FindComment(postID, int(*r.ParentID)); err != nil {
+			return RespondWithError(c, http.StatusBadRequest, "parent comment not found on this post")
+		}
+	}
+
+	comment := model.Comment{
+		PostID:    uint(postID),
+		UserID:    uint(userID),
+		ParentID:  r.ParentID,
+		Content:   r.Content,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if err := s.commentStore.CreateComment(&comment); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusCreated, comment)
+}
+
+// handleListComments returns a page of comments. By default the page is made
+// of top level comments with their replies nested below them, and total
+// counts top level comments only. ?format=flat pages through all comments in
+// the order they were written.
+func (s *Server) handleListComments(c echo.Context) error {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
+	}
+
+	if _, err = s.findVisiblePost(c, postID); err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	page, limit := pageParams(c)
+	offset := (page - 1) * limit
+
+	switch c.QueryParams().Get("format") {
+	case "flat":
+		total, err := s.commentStore.CountComments(postID, false)
+		if err != nil {
+			return RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		comments, err := s.commentStore.FindComments(postID, limit, offset)
+		if err != nil {
+			return RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return RespondWithList(c, pageList(c, comments, total, page, limit))
+	case "", "nested":
+		total, err := s.commentStore.CountComments(postID, true)
+		if err != nil {
+			return RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		comments, err := s.commentStore.FindThreads(postID, limit, offset)
+		if err != nil {
+			return RespondWithError(c, http.StatusBadRequest, err.Error())
+		}
+		return RespondWithList(c, pageList(c, nestComments(comments), total, page, limit))
+	default:
+		return RespondWithError(c, http.StatusBadRequest, "format must be nested or flat")
+	}
+}
+
+func (s *Server) handleUpdateComment(c echo.Context) error {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentID"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide commentid")
+	}
+
+	r := new(model.UpdateCommentRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if _, err = s.findVisiblePost(c, postID); err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	comment, err := s.commentStore.FindComment(postID, commentID)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	// only the author may put words in their own mouth
+	if err := s.authorize(c, comment); err != nil {
+		return RespondWithError(c, http.StatusForbidden, err.Error())
+	}
+
+	updated, err := s.commentStore.UpdateComment(comment, &model.Comment{
+		Content:   r.Content,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, updated)
+}
+
+func (s *Server) handleDeleteComment(c echo.Context) error {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
+	}
+	commentID, err := strconv.Atoi(c.Param("commentID"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide commentid")
+	}
+
+	post, err := s.findVisiblePost(c, postID)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	comment, err := s.commentStore.FindComment(postID, commentID)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	// both the comment author and the post author may remove a comment
+	if err := s.authorize(c, comment, post); err != nil {
+		return RespondWithError(c, http.StatusForbidden, err.Error())
+	}
+
+	if err := s.commentStore.DeleteComment(commentID); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusNoContent, nil)
+}
+
+// nestComments turns a flat list of comments ordered by creation time into
+// trees, returning the comments that have no parent in the list.
+func nestComments(comments []*model.Comment) []*model.Comment {
+	byID := make(map[uint]*model.Comment, len(comments))
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+	}
+
+	roots := []*model.Comment{}
+	for _, comment := range comments {
+		if comment.ParentID != nil {
+			if parent, ok := byID[*comment.ParentID]; ok {
+				parent.Replies = append(parent.Replies, comment)
+				continue
+			}
+		}
+		roots = append(roots, comment)
+	}
+
+	return roots
+}
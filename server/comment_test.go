@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComments(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	alice := &model.LoginRequest{Email: "alice@gmail.com", Password: "12345678"}
+	bob := &model.LoginRequest{Email: "bob@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "alice", Email: alice.Email, Password: alice.Password, PasswordConfirm: alice.Password})
+	createTestUser(t, model.RegisterRequest{Name: "bob", Email: bob.Email, Password: bob.Password, PasswordConfirm: bob.Password})
+
+	var u *model.User
+	u, err := srv.authStore.FindUser(u, john.Email)
+	require.NoError(t, err)
+
+	post := model.Post{UserID: u.ID, Title: "Discussion", Content: "Comment below", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	require.NoError(t, srv.postStore.CreatePost(&post))
+	postID := strconv.Itoa(int(post.ID))
+
+	request := func(method, postID, commentID string, body interface{}, cred *model.LoginRequest) (echo.Context, *httptest.ResponseRecorder) {
+		c, resp := makeRequest(method, "/v1/posts/:id/comments/:commentID", body, cred != nil, cred)
+		c.SetParamNames("id", "commentID")
+		c.SetParamValues(postID, commentID)
+		return c, resp
+	}
+
+	tests := []struct {
+		postId       string
+		body         interface{}
+		cred         *model.LoginRequest
+		expectedCode int
+	}{
+		{
+			// invalid postId value
+			postId:       "oops",
+			body:         &model.CreateCommentRequest{Content: "Nice post"},
+			cred:         alice,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// not existing postId
+			postId:       "10000",
+			body:         &model.CreateCommentRequest{Content: "Nice post"},
+			cred:         alice,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			// request invalid body field
+			postId:       postID,
+			body:         []byte(`{}`),
+			cred:         alice,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// not existing parent
+			postId:       postID,
+			body:         &model.CreateCommentRequest{Content: "Nice post", ParentID: new(uint)},
+			cred:         alice,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		c, resp := request("POST", test.postId, "", test.body, test.cred)
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleCreateComment)(c)) {
+			assert.Equal(t, test.expectedCode, resp.Code)
+		}
+	}
+
+	// missing auth token
+	c, _ := request("POST", postID, "", &model.CreateCommentRequest{Content: "Nice post"}, nil)
+	he, ok := srv.AuthenticateUser(srv.handleCreateComment)(c).(*echo.HTTPError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusUnauthorized, he.Code)
+	}
+
+	// a comment and a reply to it
+	var root, reply model.Comment
+	c, resp := request("POST", postID, "", &model.CreateCommentRequest{Content: "Nice post"}, alice)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreateComment)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &root))
+
+	c, resp = request("POST", postID, "", &model.CreateCommentRequest{Content: "Thanks", ParentID: &root.ID}, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreateComment)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &reply))
+	assert.Equal(t, root.ID, *reply.ParentID)
+
+	// nested listing
+	c, resp = request("GET", postID, "", nil, bob)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleListComments)(c)) {
+		var list model.ListResponse[model.Comment]
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+		assert.Equal(t, int64(1), list.Total)
+		comments := list.Data
+		require.Len(t, comments, 1)
+		require.Len(t, comments[0].Replies, 1)
+		assert.Equal(t, reply.ID, comments[0].Replies[0].ID)
+	}
+
+	// flat listing
+	c, resp = request("GET", postID, "", nil, bob)
+	c.QueryParams().Set("format", "flat")
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleListComments)(c)) {
+		var list model.ListResponse[model.Comment]
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+		assert.Equal(t, int64(2), list.Total)
+		assert.Len(t, list.Data, 2)
+	}
+
+	rootID := strconv.Itoa(int(root.ID))
+
+	// only the author can edit a comment, even on someone else's post
+	c, resp = request("PUT", postID, rootID, &model.UpdateCommentRequest{Content: "Edited"}, john)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleUpdateComment)(c)) {
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	}
+	c, resp = request("PUT", postID, rootID, &model.UpdateCommentRequest{Content: "Edited"}, alice)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleUpdateComment)(c)) {
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+
+	// comments can't be edited once readers can't see the post
+	_, err = srv.postStore.UpdatePost(&post, &model.Post{Status: model.PostStatusDraft, UpdatedAt: time.Now()})
+	require.NoError(t, err)
+	c, resp = request("PUT", postID, rootID, &model.UpdateCommentRequest{Content: "Edited again"}, alice)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleUpdateComment)(c)) {
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	}
+	_, err = srv.postStore.UpdatePost(&post, &model.Post{Status: model.PostStatusPublished, UpdatedAt: time.Now()})
+	require.NoError(t, err)
+
+	// someone else can't delete it, the post author can
+	c, resp = request("DELETE", postID, rootID, nil, bob)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleDeleteComment)(c)) {
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	}
+	c, resp = request("DELETE", postID, rootID, nil, john)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleDeleteComment)(c)) {
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+	}
+
+	// replies go with their parent
+	_, err = srv.commentStore.FindComment(int(post.ID), int(reply.ID))
+	assert.Error(t, err)
+
+	// and comments go with their post
+	c, resp = request("POST", postID, "", &model.CreateCommentRequest{Content: "Still here"}, bob)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreateComment)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+	require.NoError(t, srv.postStore.DeletePost(int(post.ID)))
+
+	comments, err := srv.commentStore.FindComments(int(post.ID), 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, comments)
+}
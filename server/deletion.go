@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"gorm.io/gorm"
+)
+
+// restoreWindow is how long deleted accounts and posts are kept before they
+// are purged. Users logging in meanwhile get their account back.
+const restoreWindow = 30 * 24 * time.Hour
+
+// findLoginUser finds the user completing a login, who may have deleted their
+// account within the restore window.
+func (s *Server) findLoginUser(userID int) (*model.User, error) {
+	user, err := s.userStore.FindUser(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.userStore.FindDeletedUser(userID, time.Now().Add(-restoreWindow))
+	}
+	return user, err
+}
+
+// purgeDeleted permanently deletes the accounts and posts deleted longer ago
+// than the restore window.
+func (s *Server) purgeDeleted() {
+	before := time.Now().Add(-restoreWindow)
+
+	n, err := s.userStore.PurgeDeletedUsers(before)
+	if err != nil {
+		log.Printf("[scheduler] error: purging deleted users: %s", err)
+	} else if n > 0 {
+		log.Printf("[scheduler] purged %d deleted users", n)
+	}
+
+	n, err = s.postStore.PurgeDeletedPosts(before)
+	if err != nil {
+		log.Printf("[scheduler] error: purging deleted posts: %s", err)
+	} else if n > 0 {
+		log.Printf("[scheduler] purged %d deleted posts", n)
+	}
+}
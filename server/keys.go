@@ -0,0 +1,244 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+)
+
+const (
+	// hmacKeyID identifies the JWT_SECRET key, which isn't published
+	hmacKeyID = "hs256"
+	// emailTokenAudience keeps tokens of emailed links from being used as
+	// access tokens, here or by services trusting our keys
+	emailTokenAudience = "blog-api:email"
+	// jwksMaxAge is how long services may cache our keys, so a new key has
+	// to be published for at least as long before it signs tokens
+	jwksMaxAge = 5 * time.Minute
+)
+
+// SigningKey is a key tokens are signed or verified with, identified in their
+// kid header.
+type SigningKey struct {
+	ID     string
+	method jwt.SigningMethod
+	// private is nil for keys that only verify tokens signed before a
+	// rotation
+	private interface{}
+	public  interface{}
+}
+
+// ParseSigningKey reads a PEM encoded RSA or Ed25519 key. Private keys can
+// sign tokens, public keys only verify them.
+func ParseSigningKey(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(key)
+}
+
+// NewSigningKey signs with RS256 for RSA keys of at least 2048 bits, or with
+// EdDSA for Ed25519 keys. The key ID is the RFC 7638 thumbprint of the key.
+func NewSigningKey(key interface{}) (*SigningKey, error) {
+	k := &SigningKey{}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	if public, ok := k.public.(*rsa.PublicKey); ok && public.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must have at least 2048 bits")
+	}
+	k.ID = k.jwk().thumbprint()
+	return k, nil
+}
+
+// NewHMACKey signs with HS256 and a shared secret. It's never published, as
+// anyone able to verify tokens with it could sign them too.
+func NewHMACKey(secret []byte) *SigningKey {
+	return &SigningKey{ID: hmacKeyID, method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// jwk returns the public key as a JSON Web Key, nil for HMAC keys.
+func (k *SigningKey) jwk() *jsonWebKey {
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		return &jsonWebKey{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.method.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return &jsonWebKey{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: k.method.Alg(),
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(public),
+		}
+	}
+	return nil
+}
+
+// thumbprint hashes the members of a key that define it (RFC 7638), in the
+// order and format the RFC requires.
+func (k jsonWebKey) thumbprint() string {
+	var members string
+	switch k.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// KeySet signs tokens with its signing key and verifies tokens signed with
+// any of its keys, so a new signing key can take over while tokens signed
+// with the previous one stay valid until they expire.
+type KeySet struct {
+	issuer   string
+	audience string
+	signing  *SigningKey
+	keys     map[string]*SigningKey
+}
+
+// NewKeySet signs access tokens for the audience, which services verifying
+// them check along with the issuer.
+func NewKeySet(issuer, audience string, signing *SigningKey, verifying ...*SigningKey) (*KeySet, error) {
+	if signing.private == nil {
+		return nil, errors.New("the signing key is a public key")
+	}
+
+	ks := &KeySet{issuer: issuer, audience: audience, signing: signing, keys: make(map[string]*SigningKey)}
+	for _, k := range append([]*SigningKey{signing}, verifying...) {
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// CreateToken signs an access token for a session of the user.
+func (ks *KeySet) CreateToken(id uint, sessionID string, expiration time.Duration) (string, error) {
+	return ks.sign(jwt.MapClaims{"sub": id, "sid": sessionID}, ks.audience, expiration)
+}
+
+// ValidateToken checks an access token and returns its claims.
+func (ks *KeySet) ValidateToken(tokenString string) (jwt.MapClaims, error) {
+	return ks.validate(tokenString, ks.audience)
+}
+
+func (ks *KeySet) sign(claims jwt.MapClaims, audience string, expiration time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims["iss"] = ks.issuer
+	claims["aud"] = audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(expiration).Unix()
+
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+func (ks *KeySet) validate(tokenString, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		// the key decides the algorithm, not the token
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("invalid signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !claims.VerifyIssuer(ks.issuer, true):
+		return nil, errors.New("the token has the wrong issuer")
+	case !claims.VerifyAudience(audience, true):
+		return nil, errors.New("the token is for another audience")
+	case !claims.VerifyExpiresAt(time.Now().Unix(), true):
+		return nil, errors.New("the token has expired")
+	}
+	return claims, nil
+}
+
+// jwks returns the public keys of the set, the signing key first.
+func (ks *KeySet) jwks() []*jsonWebKey {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		if id != ks.signing.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	keys := []*jsonWebKey{}
+	for _, id := range append([]string{ks.signing.ID}, ids...) {
+		if jwk := ks.keys[id].jwk(); jwk != nil {
+			keys = append(keys, jwk)
+		}
+	}
+	return keys
+}
+
+func (s *Server) RegisterWellKnownRoutes(g *echo.Group) {
+	g.GET("/jwks.json", s.handleJWKS)
+}
+
+// handleJWKS publishes the keys tokens are signed with, so other services can
+// verify them.
+func (s *Server) handleJWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwksMaxAge.Seconds())))
+	return RespondWithJSON(c, http.StatusOK, map[string]interface{}{"keys": s.keys.jwks()})
+}
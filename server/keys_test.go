@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1 and RFC 8037 appendix A.3
+	rsaKey := jsonWebKey{Kty: "RSA", E: "AQAB", N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", rsaKey.thumbprint())
+
+	edKey := jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	assert.Equal(t, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k", edKey.thumbprint())
+}
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	pkix, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		block     *pem.Block
+		canSign   bool
+		expectErr bool
+	}{
+		{name: "RSA private key", block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, canSign: true},
+		{name: "Ed25519 private key", block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}, canSign: true},
+		{name: "RSA public key", block: &pem.Block{Type: "PUBLIC KEY", Bytes: pkix}},
+		{name: "short RSA key", block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(smallKey)}, expectErr: true},
+		{name: "certificate", block: &pem.Block{Type: "CERTIFICATE", Bytes: pkix}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := ParseSigningKey(pem.EncodeToMemory(tc.block))
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, key.ID)
+
+			_, err = NewKeySet("blog-api", "blog-api", key)
+			assert.Equal(t, tc.canSign, err == nil)
+		})
+	}
+
+	// the ID of a key is the same for its private and public halves
+	private, err := NewSigningKey(rsaKey)
+	require.NoError(t, err)
+	public, err := NewSigningKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	assert.Equal(t, private.ID, public.ID)
+}
+
+func TestKeyRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	previous, err := NewSigningKey(rsaKey)
+	require.NoError(t, err)
+	previousPublic, err := NewSigningKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	next, err := NewSigningKey(edKey)
+	require.NoError(t, err)
+
+	before, err := NewKeySet("blog-api", "blog-api", previous)
+	require.NoError(t, err)
+	during, err := NewKeySet("blog-api", "blog-api", next, previousPublic)
+	require.NoError(t, err)
+	after, err := NewKeySet("blog-api", "blog-api", next)
+	require.NoError(t, err)
+
+	old, err := before.CreateToken(1, "session", time.Minute)
+	require.NoError(t, err)
+	current, err := during.CreateToken(1, "session", time.Minute)
+	require.NoError(t, err)
+
+	// tokens signed before the rotation stay valid until the previous key
+	// is dropped
+	_, err = during.ValidateToken(old)
+	assert.NoError(t, err)
+	_, err = after.ValidateToken(old)
+	assert.Error(t, err)
+
+	claims, err := during.ValidateToken(current)
+	require.NoError(t, err)
+	assert.Equal(t, "session", claims["sid"])
+	_, err = after.ValidateToken(current)
+	assert.NoError(t, err)
+	_, err = before.ValidateToken(current)
+	assert.Error(t, err)
+}
+
+func TestValidateToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signing, err := NewSigningKey(rsaKey)
+	require.NoError(t, err)
+	keys, err := NewKeySet("blog-api", "blog-api", signing)
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		all := jwt.MapClaims{"sub": 1, "sid": "session", "iss": "blog-api", "aud": "blog-api", "exp": time.Now().Add(time.Minute).Unix()}
+		for k, v := range claims {
+			all[k] = v
+		}
+		token := jwt.NewWithClaims(method, all)
+		token.Header["kid"] = signing.ID
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	publicPEM, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	emailToken, err := srv.createEmailToken(&model.User{ID: 1, Email: "johndoe@gmail.com"}, purposeVerifyEmail, nil, time.Minute)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		token     string
+		keys      *KeySet
+		expectErr bool
+	}{
+		{name: "valid", token: sign(jwt.SigningMethodRS256, rsaKey, nil), keys: keys},
+		{name: "other issuer", token: sign(jwt.SigningMethodRS256, rsaKey, jwt.MapClaims{"iss": "someone-else"}), keys: keys, expectErr: true},
+		{name: "other audience", token: sign(jwt.SigningMethodRS256, rsaKey, jwt.MapClaims{"aud": "someone-else"}), keys: keys, expectErr: true},
+		{name: "no audience", token: sign(jwt.SigningMethodRS256, rsaKey, jwt.MapClaims{"aud": nil}), keys: keys, expectErr: true},
+		{name: "expired", token: sign(jwt.SigningMethodRS256, rsaKey, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}), keys: keys, expectErr: true},
+		{name: "no expiry", token: sign(jwt.SigningMethodRS256, rsaKey, jwt.MapClaims{"exp": nil}), keys: keys, expectErr: true},
+		{name: "HS256 with the public key", token: sign(jwt.SigningMethodHS256, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicPEM}), nil), keys: keys, expectErr: true},
+		{name: "emailed link", token: emailToken, keys: srv.keys, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.keys.ValidateToken(tc.token)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	c, resp := makeRequest("GET", "/.well-known/jwks.json", nil, false, nil)
+	require.NoError(t, srv.handleJWKS(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "public, max-age=300", resp.Header().Get("Cache-Control"))
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 1)
+	published := jwks.Keys[0]
+	assert.Equal(t, "OKP", published.Kty)
+	assert.Equal(t, "EdDSA", published.Alg)
+	assert.Equal(t, published.thumbprint(), published.Kid)
+
+	// other services verify our tokens with the published key
+	token, err := srv.keys.CreateToken(1, "session", time.Minute)
+	require.NoError(t, err)
+	x, err := base64.RawURLEncoding.DecodeString(published.X)
+	require.NoError(t, err)
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		assert.Equal(t, published.Kid, token.Header["kid"])
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+	require.NoError(t, err)
+	assert.True(t, parsed.Valid)
+
+	// shared secrets are never published
+	keys, err := NewKeySet("blog-api", "blog-api", srv.keys.signing, NewHMACKey([]byte("secret")))
+	require.NoError(t, err)
+	assert.Len(t, keys.jwks(), 1)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginAttemptWindow is how long failed logins are remembered.
+const loginAttemptWindow = time.Hour
+
+var (
+	// accountBackoff locks an email address out after a few failures, no
+	// matter where the logins come from
+	accountBackoff = repository.Backoff{Free: 5, Base: 30 * time.Second, Max: 15 * time.Minute, Window: loginAttemptWindow}
+	// ipBackoff allows more failures, as many users may share an address
+	ipBackoff = repository.Backoff{Free: 20, Base: time.Second, Max: 15 * time.Minute, Window: loginAttemptWindow}
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash is compared against when there is no password to check,
+// so logins take as long whether or not the email belongs to a user.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), 10)
+		if err != nil {
+			panic(err)
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
+
+// clientIP returns the address of the client. Forwarding headers are only
+// believed with TRUST_PROXY, where the proxy in front of the API appends the
+// address it got the request from to X-Forwarded-For.
+func (s *Server) clientIP(c echo.Context) string {
+	if s.trustProxy {
+		if forwarded := c.Request().Header.Get(echo.HeaderXForwardedFor); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+		if ip := c.Request().Header.Get(echo.HeaderXRealIP); ip != "" {
+			return ip
+		}
+	}
+	ip, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return c.Request().RemoteAddr
+	}
+	return ip
+}
+
+type loginKey struct {
+	key     string
+	backoff repository.Backoff
+}
+
+// loginKeys are the counters a login attempt is checked against: one for the
+// client address and one for the account, which doesn't have to exist.
+// Addresses are hashed so the emails attackers try aren't stored.
+func (s *Server) loginKeys(c echo.Context, email string) (ip, account loginKey) {
+	ip = loginKey{key: "ip:" + s.clientIP(c), backoff: ipBackoff}
+	account = loginKey{key: "email:" + hashToken(strings.ToLower(email)), backoff: accountBackoff}
+	return ip, account
+}
+
+// loginLockout returns how long the keys are still locked out, 0 if none is.
+func (s *Server) loginLockout(keys ...loginKey) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, k := range keys {
+		attempt, err := s.loginAttempts.FindLoginAttempt(k.key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt.IsLocked(now) && attempt.LockedUntil.Sub(now) > wait {
+			wait = attempt.LockedUntil.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// failLogin counts a failed login against every key.
+func (s *Server) failLogin(keys ...loginKey) error {
+	now := time.Now()
+	for _, k := range keys {
+		if _, err := s.loginAttempts.FailLogin(k.key, k.backoff, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func respondWithLockout(c echo.Context, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds()+1)))
+	return RespondWithError(c, http.StatusTooManyRequests, "Too many failed logins, try again later")
+}
+
+func (s *Server) pruneLoginAttempts() {
+	n, err := s.loginAttempts.PruneLoginAttempts(time.Now().Add(-loginAttemptWindow))
+	if err != nil {
+		log.Printf("[scheduler] error: pruning login attempts: %s", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[scheduler] pruned %d login attempt counters", n)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := repository.Backoff{Free: 2, Base: time.Second, Max: 10 * time.Second, Window: time.Hour}
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 1, expected: 0},
+		{failures: 2, expected: 0},
+		{failures: 3, expected: time.Second},
+		{failures: 4, expected: 2 * time.Second},
+		{failures: 6, expected: 8 * time.Second},
+		{failures: 7, expected: 10 * time.Second},
+		{failures: 1000, expected: 10 * time.Second},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, b.Lockout(tc.failures), "after %d failures", tc.failures)
+	}
+}
+
+func TestLoginAttemptStores(t *testing.T) {
+	stores := map[string]repository.LoginAttemptStore{
+		"postgres": srv.loginAttempts,
+		"memory":   repository.NewMemoryLoginAttemptRepository(),
+	}
+	b := repository.Backoff{Free: 1, Base: time.Minute, Max: time.Hour, Window: time.Hour}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			key := "test:" + name
+			now := time.Now()
+
+			attempt, err := store.FailLogin(key, b, now)
+			require.NoError(t, err)
+			assert.Equal(t, 1, attempt.Failures)
+			assert.False(t, attempt.IsLocked(now))
+
+			attempt, err = store.FailLogin(key, b, now)
+			require.NoError(t, err)
+			assert.Equal(t, 2, attempt.Failures)
+			assert.WithinDuration(t, now.Add(time.Minute), attempt.LockedUntil, time.Millisecond)
+
+			attempt, err = store.FailLogin(key, b, now)
+			require.NoError(t, err)
+			assert.Equal(t, 3, attempt.Failures)
+			assert.WithinDuration(t, now.Add(2*time.Minute), attempt.LockedUntil, time.Millisecond)
+
+			attempt, err = store.FindLoginAttempt(key)
+			require.NoError(t, err)
+			assert.Equal(t, 3, attempt.Failures)
+			assert.True(t, attempt.IsLocked(now))
+
+			// failures are forgotten after the window
+			later := now.Add(2 * time.Hour)
+			attempt, err = store.FailLogin(key, b, later)
+			require.NoError(t, err)
+			assert.Equal(t, 1, attempt.Failures)
+			assert.False(t, attempt.IsLocked(later))
+
+			require.NoError(t, store.ResetLogin(key))
+			attempt, err = store.FindLoginAttempt(key)
+			require.NoError(t, err)
+			assert.Equal(t, 0, attempt.Failures)
+
+			_, err = store.FailLogin(key, b, now.Add(-2*time.Hour))
+			require.NoError(t, err)
+			n, err := store.PruneLoginAttempts(now.Add(-time.Hour))
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	locked := &model.LoginRequest{Email: "locked@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "locked", Email: locked.Email, Password: locked.Password, PasswordConfirm: locked.Password})
+	other := &model.LoginRequest{Email: "unlocked@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "unlocked", Email: other.Email, Password: other.Password, PasswordConfirm: other.Password})
+
+	login := func(email, password, ip string) *httptest.ResponseRecorder {
+		c, resp := makeRequest("POST", "/v1/auth/login", &model.LoginRequest{Email: email, Password: password}, false, nil)
+		c.Request().RemoteAddr = ip + ":1234"
+		require.NoError(t, srv.handleLogin(c))
+		return resp
+	}
+
+	t.Run("unknown email looks like a wrong password", func(t *testing.T) {
+		wrong := login(locked.Email, "wrong password", "198.51.100.1")
+		unknown := login("nobody@gmail.com", "wrong password", "198.51.100.1")
+		assert.Equal(t, http.StatusUnauthorized, wrong.Code)
+		assert.Equal(t, wrong.Code, unknown.Code)
+		assert.Equal(t, wrong.Body.String(), unknown.Body.String())
+	})
+
+	t.Run("account is locked out from every address", func(t *testing.T) {
+		// one failure was counted above
+		for i := 2; i <= accountBackoff.Free+1; i++ {
+			resp := login(locked.Email, "wrong password", fmt.Sprintf("198.51.100.%d", i))
+			require.Equal(t, http.StatusUnauthorized, resp.Code)
+		}
+
+		resp := login(locked.Email, locked.Password, "198.51.100.200")
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		retryAfter, err := strconv.Atoi(resp.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		assert.InDelta(t, accountBackoff.Base.Seconds(), retryAfter, 2)
+
+		// other accounts can still log in from the same addresses
+		assert.Equal(t, http.StatusOK, login(other.Email, other.Password, "198.51.100.2").Code)
+	})
+
+	t.Run("address is locked out for every account", func(t *testing.T) {
+		for i := 0; i <= ipBackoff.Free; i++ {
+			resp := login(fmt.Sprintf("guess%d@gmail.com", i), "wrong password", "203.0.113.7")
+			require.Equal(t, http.StatusUnauthorized, resp.Code)
+		}
+
+		assert.Equal(t, http.StatusTooManyRequests, login(other.Email, other.Password, "203.0.113.7").Code)
+		assert.Equal(t, http.StatusOK, login(other.Email, other.Password, "203.0.113.8").Code)
+	})
+
+	t.Run("logging in forgets the failures of the account", func(t *testing.T) {
+		for i := 0; i < accountBackoff.Free; i++ {
+			require.Equal(t, http.StatusUnauthorized, login(other.Email, "wrong password", "198.51.100.50").Code)
+		}
+		require.Equal(t, http.StatusOK, login(other.Email, other.Password, "198.51.100.50").Code)
+
+		attempt, err := srv.loginAttempts.FindLoginAttempt("email:" + hashToken(other.Email))
+		require.NoError(t, err)
+		assert.Equal(t, 0, attempt.Failures)
+	})
+}
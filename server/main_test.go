@@ -1,12 +1,16 @@
 package server
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -15,6 +19,8 @@ import (
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/model"
 	"github.com/orhanfatih/blog-api/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -22,24 +28,74 @@ import (
 
 var srv *Server
 
+// mailLog holds the emails sent during the tests
+var mailLog bytes.Buffer
+
 func TestMain(m *testing.M) {
 	db := mockDatabase()
 
-	db.AutoMigrate(&model.User{}, &model.Post{})
+	userStore := repository.NewUserRepository(db)
+	if err := userStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate users: %v", err)
+	}
+
+	db.AutoMigrate(&model.User{}, &model.Post{}, &model.Session{}, &model.Comment{}, &model.Tag{}, &model.PostRevision{}, &model.PostSlug{}, &model.Media{}, &model.MediaVariant{}, &model.PasswordReset{}, &model.RecoveryCode{}, &model.LoginChallenge{}, &model.Identity{}, &model.OIDCState{}, &model.LoginAttempt{}, &model.RateLimitBucket{}, &model.APIKey{})
 
 	authStore := repository.NewAuthRepository(db)
 	postStore := repository.NewPostRepository(db)
-	userStore := repository.NewUserRepository(db)
-	srv = NewServer(authStore, postStore, userStore)
+	if err := postStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate post slugs: %v", err)
+	}
+	sessionStore := repository.NewSessionRepository(db)
+	commentStore := repository.NewCommentRepository(db)
+	tagStore := repository.NewTagRepository(db)
+	revisionStore := repository.NewRevisionRepository(db)
+	if err := revisionStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate post revisions: %v", err)
+	}
+	searchStore := repository.NewSearchRepository(db)
+	if err := searchStore.Migrate(); err != nil {
+		log.Fatalf("failed to migrate search index: %v", err)
+	}
+	mediaStore := repository.NewMediaRepository(db)
+	mediaDir, err := os.MkdirTemp("", "blog-api-media")
+	if err != nil {
+		log.Fatalf("failed to create media directory: %v", err)
+	}
+	blobStore, err := repository.NewLocalBlobRepository(mediaDir)
+	if err != nil {
+		log.Fatalf("failed to set up media storage: %v", err)
+	}
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("failed to generate signing key: %v", err)
+	}
+	signing, err := NewSigningKey(private)
+	if err != nil {
+		log.Fatalf("failed to set up signing key: %v", err)
+	}
+	keys, err := NewKeySet("blog-api", "blog-api", signing)
+	if err != nil {
+		log.Fatalf("failed to set up signing keys: %v", err)
+	}
+	srv = NewServer(authStore, postStore, userStore, sessionStore, commentStore, tagStore, searchStore, revisionStore, mediaStore, blobStore, repository.NewLogMailer("blog-api@localhost", &mailLog), repository.NewLoginAttemptRepository(db), repository.NewRateLimitRepository(db), keys)
+
+	srv.baseURL = "http://example.com"
 
 	g := srv.E.Group("/v1")
 
 	srv.RegisterAuthRoutes(g)
 	srv.RegisterPostRoutes(g)
+	srv.RegisterCommentRoutes(g)
+	srv.RegisterRevisionRoutes(g)
+	srv.RegisterMediaRoutes(g)
+	srv.RegisterTagRoutes(g)
 	srv.RegisterUserRoutes(g)
+	srv.RegisterAdminRoutes(g)
 
 	exitCode := m.Run()
 	teardown(db)
+	os.RemoveAll(mediaDir)
 
 	os.Exit(exitCode)
 }
@@ -53,7 +109,7 @@ func mockDatabase() *gorm.DB {
 	}
 
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Europe/Istanbul", os.Getenv("POSTGRES_HOST"), os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_DB"), os.Getenv("POSTGRES_PORT"))
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 		panic(err)
@@ -64,7 +120,7 @@ func mockDatabase() *gorm.DB {
 
 func teardown(db *gorm.DB) {
 	migrator := db.Migrator()
-	migrator.DropTable(&model.User{}, &model.Post{})
+	migrator.DropTable(&model.User{}, &model.Post{}, &model.Session{}, &model.Comment{}, &model.Tag{}, "post_tags", &model.PostRevision{}, &model.PostSlug{}, &model.Media{}, &model.MediaVariant{}, "post_media", &model.PasswordReset{}, &model.RecoveryCode{}, &model.LoginChallenge{}, &model.Identity{}, &model.OIDCState{}, &model.LoginAttempt{}, &model.RateLimitBucket{}, &model.APIKey{})
 }
 
 func makeRequest(method, url string, body interface{}, isAuthenticatedRequest bool, cred *model.LoginRequest) (echo.Context, *httptest.ResponseRecorder) {
@@ -73,6 +129,7 @@ func makeRequest(method, url string, body interface{}, isAuthenticatedRequest bo
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	if isAuthenticatedRequest {
 		req.AddCookie(bearerToken(cred))
+		withCSRF(req)
 	}
 
 	rec := httptest.NewRecorder()
@@ -89,7 +146,7 @@ func bearerToken(cred *model.LoginRequest) *http.Cookie {
 		return nil
 	}
 
-	token, _ := CreateToken(u.ID, time.Hour, os.Getenv("JWT_SECRET"))
+	token, _, _ := srv.newSession(u.ID)
 
 	return &http.Cookie{
 		Name:     "access-token",
@@ -99,3 +156,42 @@ func bearerToken(cred *model.LoginRequest) *http.Cookie {
 		Path:     "/",
 	}
 }
+
+// withCSRF adds a matching CSRF cookie and header to the request.
+func withCSRF(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: "csrf-token", Value: "csrf-test-token"})
+	req.Header.Set(csrfHeader, "csrf-test-token")
+}
+
+func createTestUser(t *testing.T, r model.RegisterRequest) {
+	c, resp := makeRequest("POST", "/v1/auth/register", r, false, nil)
+
+	if assert.NoError(t, srv.handleRegister(c)) {
+		require.Equal(t, http.StatusCreated, resp.Code)
+	}
+	verifyTestUser(t, r.Email)
+}
+
+// verifyTestUser opens the last verification link sent to the email address.
+func verifyTestUser(t *testing.T, email string) {
+	c, resp := makeRequest("GET", "/v1/auth/verify?token="+lastMailToken(t, email, "verify"), nil, false, nil)
+	require.NoError(t, srv.handleVerifyEmail(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+}
+
+// lastMailToken returns the token of the link to the path in the last email
+// sent to the address.
+func lastMailToken(t *testing.T, email, path string) string {
+	messages := regexp.MustCompile(`(?m)^From `).Split(mailLog.String(), -1)
+	link := regexp.MustCompile(`/` + path + `\?token=([\w.-]+)`)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if !strings.Contains(messages[i], "\nTo: "+email+"\n") {
+			continue
+		}
+		match := link.FindStringSubmatch(messages[i])
+		require.NotNil(t, match, "no link in the last email to %s", email)
+		return match[1]
+	}
+	require.FailNow(t, "no email sent to "+email)
+	return ""
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxMediaSize = 10 << 20
+	// maxMediaPixels keeps small files that decode to huge images out
+	maxMediaPixels = 50_000_000
+
+	// mediaGracePeriod is how long uploads are kept without a post using them,
+	// giving authors time to write the post.
+	mediaGracePeriod = 24 * time.Hour
+	mediaGCBatchSize = 100
+)
+
+// mediaTypes are the content types accepted for upload, as sniffed from the
+// uploaded bytes rather than trusted from the request.
+var mediaTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+var errUnsupportedMedia = errors.New("upload a JPEG, PNG, GIF or WebP image")
+
+func (s *Server) RegisterMediaRoutes(g *echo.Group) {
+	router := g.Group("/media")
+	router.Use(s.AuthenticateUser)
+	s.allowAPIKey(router.POST("", s.handleUploadMedia), model.ScopePostsWrite)
+	s.allowAPIKey(router.GET("/:id", s.handleGetMedia), model.ScopePostsRead)
+	s.allowAPIKey(router.GET("/:id/content", s.handleGetMediaContent), model.ScopePostsRead)
+}
+
+// handleUploadMedia stores the image in the file field of a multipart form.
+func (s *Server) handleUploadMedia(c echo.Context) error {
+	userID, ok := c.Get("userID").(int)
+	if !ok {
+		return RespondWithError(c, http.StatusInternalServerError, "User ID not found in context")
+	}
+
+	// leave some room for the rest of the form
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxMediaSize+1<<20)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide an image in the file field: "+err.Error())
+	}
+	if fh.Size > maxMediaSize {
+		return RespondWithError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Images can be at most %d MB", maxMediaSize>>20))
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+	defer f.Close()
+
+	media, err := inspectMedia(f)
+	if errors.Is(err, errUnsupportedMedia) {
+		return RespondWithError(c, http.StatusUnsupportedMediaType, err.Error())
+	}
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	key, err := randomToken(24)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	media.UserID = uint(userID)
+	media.Key = "media/" + key
+	media.Size = fh.Size
+	media.CreatedAt = time.Now()
+	media.Status = model.MediaStatusPending
+
+	if err := s.blobStore.PutBlob(media.Key, f, media.Size, media.ContentType); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := s.mediaStore.CreateMedia(media); err != nil {
+		if err := s.blobStore.DeleteBlob(media.Key); err != nil {
+			log.Printf("failed to delete blob %s: %s", media.Key, err)
+		}
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	// the variants are generated in the background, the scheduler picks the
+	// upload up later if the queue is full
+	s.mediaQueue.push(media.ID)
+
+	return RespondWithJSON(c, http.StatusCreated, media)
+}
+
+// inspectMedia sniffs the content type and reads the dimensions of an
+// uploaded image, leaving f at its start.
+func inspectMedia(f multipart.File) (*model.Media, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if !mediaTypes[contentType] {
+		return nil, errUnsupportedMedia
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, errors.New("the image is invalid or corrupt")
+	}
+	if config.Width < 1 || config.Height < 1 || config.Width*config.Height > maxMediaPixels {
+		return nil, errors.New("the image dimensions are out of range")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &model.Media{ContentType: contentType, Width: config.Width, Height: config.Height}, nil
+}
+
+func (s *Server) handleGetMedia(c echo.Context) error {
+	media, code, err := s.findVisibleMedia(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, media)
+}
+
+// handleGetMediaContent serves a variant of an image, ?variant= defaulting to
+// the original size.
+func (s *Server) handleGetMediaContent(c echo.Context) error {
+	media, code, err := s.findVisibleMedia(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	name := c.QueryParam("variant")
+	if name == "" {
+		name = model.MediaVariantOriginal
+	}
+	if !isMediaVariant(name) {
+		return RespondWithError(c, http.StatusBadRequest, "variant must be thumbnail, medium or original")
+	}
+
+	if media.Status != model.MediaStatusReady {
+		if media.Status == model.MediaStatusPending {
+			c.Response().Header().Set("Retry-After", "1")
+		}
+		return RespondWithError(c, http.StatusConflict, "The image is "+media.Status)
+	}
+
+	var variant *model.MediaVariant
+	for _, v := range media.Variants {
+		if v.Name == name {
+			variant = v
+		}
+	}
+	if variant == nil {
+		return RespondWithError(c, http.StatusNotFound, "not existing/valid variant")
+	}
+
+	blob, err := s.blobStore.GetBlob(variant.Key)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+	defer blob.Close()
+
+	c.Response().Header().Set("Content-Length", strconv.FormatInt(variant.Size, 10))
+	c.Response().Header().Set("Cache-Control", "private, max-age=86400")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Stream(http.StatusOK, variant.ContentType, blob)
+}
+
+func isMediaVariant(name string) bool {
+	for _, size := range mediaVariantSizes {
+		if size.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// findVisibleMedia finds media the user of the request is allowed to see:
+// their own uploads and media used by published posts.
+func (s *Server) findVisibleMedia(c echo.Context) (*model.Media, int, error) {
+	mediaID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Provide media id")
+	}
+
+	media, err := s.mediaStore.FindMedia(mediaID)
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("not existing/valid media id")
+	}
+
+	if s.authorize(c, media) != nil {
+		published, err := s.mediaStore.IsMediaPublished(media.ID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if !published {
+			return nil, http.StatusNotFound, errors.New("not existing/valid media id")
+		}
+	}
+	return media, http.StatusOK, nil
+}
+
+// findPostMedia loads the media a post refers to, checking that they exist
+// and that the user of the request uploaded them.
+func (s *Server) findPostMedia(c echo.Context, refs []*model.Media) ([]*model.Media, error) {
+	if len(refs) == 0 {
+		return refs, nil
+	}
+
+	ids := make([]uint, 0, len(refs))
+	for _, m := range refs {
+		ids = append(ids, m.ID)
+	}
+
+	media, err := s.mediaStore.FindMediaByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(media) != len(ids) {
+		return nil, errors.New("not existing/valid media id")
+	}
+	for _, m := range media {
+		if err := s.authorize(c, m); err != nil {
+			return nil, errors.New("posts can only use media uploaded by their author")
+		}
+	}
+	return media, nil
+}
+
+// collectMedia deletes media uploaded before the given time that no post
+// uses, along with the blobs of their upload and variants.
+func (s *Server) collectMedia(before time.Time) {
+	media, err := s.mediaStore.FindUnreferencedMedia(before, mediaGCBatchSize)
+	if err != nil {
+		log.Printf("[scheduler] error: finding unused media: %s", err)
+		return
+	}
+
+	deleted := 0
+	for _, m := range media {
+		// the record goes first, so no post can start using a missing blob
+		if err := s.mediaStore.DeleteMedia(m.ID); err != nil {
+			if !errors.Is(err, repository.ErrMediaInUse) {
+				log.Printf("[scheduler] error: deleting media %d: %s", m.ID, err)
+			}
+			continue
+		}
+		keys := []string{m.Key}
+		for _, v := range m.Variants {
+			keys = append(keys, v.Key)
+		}
+		for _, key := range keys {
+			if err := s.blobStore.DeleteBlob(key); err != nil {
+				log.Printf("[scheduler] error: deleting blob %s: %s", key, err)
+			}
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("[scheduler] deleted %d unused media", deleted)
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func makeUploadRequest(t *testing.T, field string, content []byte, cred *model.LoginRequest) (echo.Context, *httptest.ResponseRecorder) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile(field, "upload")
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	req := httptest.NewRequest("POST", "/v1/media", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	req.AddCookie(bearerToken(cred))
+	withCSRF(req)
+
+	rec := httptest.NewRecorder()
+	return srv.E.NewContext(req, rec), rec
+}
+
+func TestMedia(t *testing.T) {
+	createTestUser(t, model.RegisterRequest{
+		Name:            "mia",
+		Email:           "mia@gmail.com",
+		Password:        "12345678",
+		PasswordConfirm: "12345678",
+	})
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	mia := &model.LoginRequest{Email: "mia@gmail.com", Password: "12345678"}
+
+	tests := []struct {
+		field        string
+		content      []byte
+		expectedCode int
+	}{
+		{
+			// not an image
+			field:        "file",
+			content:      []byte("<html><script>alert(1)</script></html>"),
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			// png signature without an image
+			field:        "file",
+			content:      testPNG(t, 3, 2)[:20],
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// wrong field
+			field:        "image",
+			content:      testPNG(t, 3, 2),
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		c, resp := makeUploadRequest(t, test.field, test.content, john)
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleUploadMedia)(c)) {
+			assert.Equal(t, test.expectedCode, resp.Code)
+		}
+	}
+
+	upload := func(content []byte, cred *model.LoginRequest) model.Media {
+		c, resp := makeUploadRequest(t, "file", content, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleUploadMedia)(c))
+		require.Equal(t, http.StatusCreated, resp.Code)
+
+		var media model.Media
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &media))
+		return media
+	}
+	getContent := func(media model.Media, variant string, cred *model.LoginRequest) *httptest.ResponseRecorder {
+		c, resp := makeRequest("GET", "/v1/media/:id/content?variant="+variant, nil, true, cred)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(int(media.ID)))
+		require.NoError(t, srv.AuthenticateUser(srv.handleGetMediaContent)(c))
+		return resp
+	}
+
+	media := upload(testPNG(t, 3, 2), john)
+	assert.Equal(t, "image/png", media.ContentType)
+	assert.Equal(t, 3, media.Width)
+	assert.Equal(t, 2, media.Height)
+	assert.Equal(t, model.MediaStatusPending, media.Status)
+
+	// variants are generated in the background
+	assert.Equal(t, http.StatusConflict, getContent(media, "", john).Code)
+	require.NoError(t, srv.processMedia(media.ID))
+
+	resp := getContent(media, "", john)
+	if assert.Equal(t, http.StatusOK, resp.Code) {
+		assert.Equal(t, "image/png", resp.Header().Get(echo.HeaderContentType))
+		config, err := png.DecodeConfig(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, 3, config.Width)
+		assert.Equal(t, 2, config.Height)
+	}
+
+	// unused uploads are private
+	assert.Equal(t, http.StatusNotFound, getContent(media, "", mia).Code)
+
+	// posts can only use their author's media
+	other := upload(testPNG(t, 3, 2), mia)
+	c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Stolen", Content: "Not mine", MediaIDs: []uint{other.ID}}, true, john)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c)) {
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	}
+
+	c, resp = makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Gallery", Content: "Look", MediaIDs: []uint{media.ID}}, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	var post model.Post
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+	if assert.Len(t, post.Media, 1) {
+		assert.Equal(t, media.ID, post.Media[0].ID)
+	}
+
+	// media of published posts are visible to everyone
+	assert.Equal(t, http.StatusOK, getContent(media, "", mia).Code)
+
+	// unused media are collected after the grace period
+	srv.collectMedia(time.Now())
+	assert.Equal(t, http.StatusNotFound, getContent(other, "", mia).Code)
+	assert.Equal(t, http.StatusOK, getContent(media, "", mia).Code)
+
+	// and so are the media a post stops using
+	c, resp = makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{MediaIDs: []uint{}}, true, john)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(int(post.ID)))
+	require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	srv.collectMedia(time.Now())
+	assert.Equal(t, http.StatusNotFound, getContent(media, "", john).Code)
+
+	// a photo taken with the camera on its side, with its location
+	photo := upload(testJPEG(t, 800, 400, 6), john)
+	require.NoError(t, srv.processMedia(photo.ID))
+
+	c, resp = makeRequest("GET", "/v1/media/:id", nil, true, john)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(int(photo.ID)))
+	require.NoError(t, srv.AuthenticateUser(srv.handleGetMedia)(c))
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &photo))
+	assert.Equal(t, model.MediaStatusReady, photo.Status)
+	assert.Len(t, photo.Variants, 3)
+
+	variants := []struct {
+		name   string
+		width  int
+		height int
+	}{
+		{model.MediaVariantThumbnail, 160, 320},
+		{model.MediaVariantMedium, 400, 800},
+		{model.MediaVariantOriginal, 400, 800},
+	}
+	for _, v := range variants {
+		resp := getContent(photo, v.name, john)
+		require.Equal(t, http.StatusOK, resp.Code, v.name)
+		assert.Equal(t, "image/jpeg", resp.Header().Get(echo.HeaderContentType))
+		assert.NotContains(t, resp.Body.String(), "Exif", v.name)
+		assert.NotContains(t, resp.Body.String(), "GPS", v.name)
+
+		config, err := jpeg.DecodeConfig(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, v.width, config.Width, v.name)
+		assert.Equal(t, v.height, config.Height, v.name)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, getContent(photo, "huge", john).Code)
+}
+
+// testJPEG encodes a JPEG with EXIF data giving its orientation and the GPS
+// position it was taken at.
+func testJPEG(t *testing.T, width, height, orientation int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil))
+	encoded := buf.Bytes()
+
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1}
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, "GPS 52.3676 N 4.9041 E"...)
+
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}
+	segment = append(segment, exif...)
+
+	jpg := append([]byte{}, encoded[:2]...)
+	jpg = append(jpg, segment...)
+	return append(jpg, encoded[2:]...)
+}
+
+// fakeS3 stands in for an S3 compatible service, checking request signatures
+// the way S3 does.
+type fakeS3 struct {
+	config  repository.S3Config
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s3 *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s3.validSignature(r) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s3.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, ok := s3.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(s3.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s3 *fakeS3) validSignature(r *http.Request) bool {
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != 16 {
+		return false
+	}
+	scope := amzDate[:8] + "/" + s3.config.Region + "/s3/aws4_request"
+
+	canonical := strings.Join([]string{
+		r.Method, r.URL.EscapedPath(), r.URL.RawQuery,
+		"host:" + r.Host,
+		"x-amz-content-sha256:" + r.Header.Get("X-Amz-Content-Sha256"),
+		"x-amz-date:" + amzDate,
+		"",
+		"host;x-amz-content-sha256;x-amz-date",
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+
+	key := []byte("AWS4" + s3.config.SecretKey)
+	for _, part := range []string{amzDate[:8], s3.config.Region, "s3", "aws4_request"} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])))
+
+	expected := "AWS4-HMAC-SHA256 Credential=" + s3.config.AccessKey + "/" + scope +
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + hex.EncodeToString(mac.Sum(nil))
+	return r.Header.Get("Authorization") == expected
+}
+
+func TestS3BlobStore(t *testing.T) {
+	config := repository.S3Config{Region: "eu-central-1", Bucket: "media", AccessKey: "minio", SecretKey: "minio-secret"}
+	fake := &fakeS3{config: config, objects: map[string][]byte{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	config.Endpoint = ts.URL
+	store := repository.NewS3BlobRepository(config)
+
+	content := testPNG(t, 1, 1)
+	require.NoError(t, store.PutBlob("media/a", bytes.NewReader(content), int64(len(content)), "image/png"))
+	assert.Contains(t, fake.objects, "/media/media/a")
+
+	blob, err := store.GetBlob("media/a")
+	require.NoError(t, err)
+	stored, err := io.ReadAll(blob)
+	blob.Close()
+	require.NoError(t, err)
+	assert.Equal(t, content, stored)
+
+	require.NoError(t, store.DeleteBlob("media/a"))
+	_, err = store.GetBlob("media/a")
+	assert.ErrorIs(t, err, repository.ErrBlobNotFound)
+
+	// keys can't leave the bucket
+	assert.Error(t, store.PutBlob("../other/a", bytes.NewReader(content), int64(len(content)), "image/png"))
+
+	// wrong credentials are refused
+	config.SecretKey = "wrong"
+	assert.Error(t, repository.NewS3BlobRepository(config).PutBlob("media/b", bytes.NewReader(content), int64(len(content)), "image/png"))
+}
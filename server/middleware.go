@@ -3,68 +3,51 @@ package server
 import (
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
-	"time"
+	"strings"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo"
 )
 
-func CreateToken(id uint, expiration time.Duration, secretKey string) (string, error) {
-	claims := jwt.MapClaims{
-		"sub": id,
-		"iss": "blog-api",
-		"iat": time.Now().UTC().Unix(),
-		"exp": time.Now().Add(expiration).UTC().Unix(),
+// accessToken returns the token of the request and whether it was read from
+// the access-token cookie rather than an Authorization: Bearer header.
+func accessToken(c echo.Context) (string, bool) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):]), false
 	}
 
-	// create a new token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString([]byte(secretKey))
+	cookie, err := c.Cookie("access-token")
 	if err != nil {
-		return "", nil
+		return "", false
 	}
-
-	return tokenString, nil
+	return cookie.Value, true
 }
 
-func ValidateToken(tokenString string) (jwt.MapClaims, error) {
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Check the signing method.
-		if token.Method != jwt.SigningMethodHS256 {
-			return nil, fmt.Errorf("invalid signing method: %v", token.Method)
+func (s *Server) AuthenticateUser(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// Get the access token from the Authorization header or the cookie
+		token, fromCookie := accessToken(c)
+		if token == "" {
+			return echo.NewHTTPError(http.StatusUnauthorized, "You must be logged in to access this resource.")
 		}
 
-		secret := []byte(os.Getenv("JWT_SECRET"))
-		return secret, nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return nil, err
-	}
-
-	return claims, nil
-}
+		// API keys are only sent by scripts, never in the cookie
+		if !fromCookie && strings.HasPrefix(token, apiKeyPrefix) {
+			if err := s.authenticateAPIKey(c, token); err != nil {
+				return err
+			}
+			return next(c)
+		}
 
-func AuthenticateUser(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		// Get the access token from the cookie
-		cookie, err := c.Cookie("access-token")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "You must be logged in to access this resource.")
+		// Cookies are sent by the browser on its own, so unsafe requests
+		// using them must prove they come from our client
+		if fromCookie && !isSafeMethod(c.Request().Method) && !validCSRF(c) {
+			return echo.NewHTTPError(http.StatusForbidden, "Invalid CSRF token")
 		}
 
 		// Validate the token
-		claims, err := ValidateToken(cookie.Value)
+		claims, err := s.keys.ValidateToken(token)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 		}
@@ -75,8 +58,19 @@ func AuthenticateUser(next echo.HandlerFunc) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Token sub extracting error")
 		}
 
+		// Reject tokens whose session was revoked by logout or refresh token reuse
+		sessionID, ok := claims["sid"].(string)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+		}
+		active, err := s.sessionStore.IsActive(sessionID)
+		if err != nil || !active {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Session has been revoked")
+		}
+
 		// Set the user ID in the request context for later use in the route handler
 		c.Set("userID", userID)
+		c.Set("sessionID", sessionID)
 
 		// Proceed to the next middleware or the route handler
 		return next(c)
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	"gorm.io/gorm"
+)
+
+// oidcLoginTTL is how long users have to log in at the identity provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// AddOIDCProvider lets users log in with an OpenID Connect identity provider
+// at /v1/auth/oidc/<name>.
+func (s *Server) AddOIDCProvider(config OIDCConfig) {
+	s.oidcProviders[config.Name] = newOIDCProvider(config)
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (s *Server) oidcRedirectURI(provider string) string {
+	return s.appURL("/v1/auth/oidc/"+provider+"/callback", nil)
+}
+
+// oidcStateCookie binds a login at an identity provider to the browser that
+// started it, so nobody can make a victim complete a login of their own.
+func (s *Server) oidcStateCookie(value string, expires time.Time) *http.Cookie {
+	cookie := s.newCookie("oidc-state", value, "/v1/auth/oidc", expires, true)
+	// the provider redirects back from another site
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	return cookie
+}
+
+// handleOIDCLogin sends the user to log in at the identity provider.
+func (s *Server) handleOIDCLogin(c echo.Context) error {
+	name := c.Param("provider")
+	provider, ok := s.oidcProviders[name]
+	if !ok {
+		return RespondWithError(c, http.StatusNotFound, "Unknown identity provider")
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	target, err := provider.authCodeURL(s.oidcRedirectURI(name), state, nonce, verifier)
+	if err != nil {
+		log.Printf("failed to reach identity provider %s: %s", name, err)
+		return RespondWithError(c, http.StatusBadGateway, "The identity provider can't be reached")
+	}
+
+	expires := time.Now().Add(oidcLoginTTL)
+	if err := s.authStore.CreateOIDCState(&model.OIDCState{
+		StateHash: hashToken(state),
+		Provider:  name,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: expires,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	c.SetCookie(s.oidcStateCookie(state, expires))
+	return c.Redirect(http.StatusFound, target)
+}
+
+// handleOIDCCallback completes a login at the identity provider, logging in
+// the user linked to the identity or registering a new one.
+func (s *Server) handleOIDCCallback(c echo.Context) error {
+	name := c.Param("provider")
+	provider, ok := s.oidcProviders[name]
+	if !ok {
+		return RespondWithError(c, http.StatusNotFound, "Unknown identity provider")
+	}
+
+	if e := c.QueryParam("error"); e != "" {
+		return RespondWithError(c, http.StatusBadRequest, "The identity provider refused the login: "+e)
+	}
+
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie("oidc-state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return RespondWithError(c, http.StatusBadRequest, "The login was started in another browser or has expired")
+	}
+	c.SetCookie(s.oidcStateCookie("", time.Now().Add(-time.Hour)))
+
+	login, err := s.authStore.TakeOIDCState(hashToken(state))
+	if errors.Is(err, repository.ErrInvalidOIDCState) || (err == nil && login.Provider != name) {
+		return RespondWithError(c, http.StatusBadRequest, "The login was started in another browser or has expired")
+	}
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	rawIDToken, err := provider.exchange(c.QueryParam("code"), s.oidcRedirectURI(name), login.Verifier)
+	if err != nil {
+		log.Printf("failed to exchange code with identity provider %s: %s", name, err)
+		return RespondWithError(c, http.StatusBadGateway, "The identity provider didn't accept the login")
+	}
+
+	claims, err := provider.verifyIDToken(rawIDToken, login.Nonce)
+	if err != nil {
+		log.Printf("invalid ID token from identity provider %s: %s", name, err)
+		return RespondWithError(c, http.StatusBadGateway, "The identity provider sent an invalid ID token")
+	}
+
+	user, code, err := s.oidcUser(name, claims)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	if user.IsSuspended() {
+		return RespondWithError(c, http.StatusForbidden, "Your account has been suspended")
+	}
+
+	if user.HasTwoFactor() {
+		return s.startLoginChallenge(c, user, false)
+	}
+
+	return s.startSession(c, user, false)
+}
+
+// oidcUser finds the user linked to an identity. Identities with a verified
+// email address are linked to the user with that address, who must have
+// verified it too, so nobody can register someone else's address ahead of
+// them and take their account over. Otherwise a new user is registered.
+func (s *Server) oidcUser(provider string, claims jwt.MapClaims) (*model.User, int, error) {
+	subject, _ := claims["sub"].(string)
+	identity, err := s.authStore.FindIdentity(provider, subject)
+	if err == nil {
+		user, err := s.findLoginUser(int(identity.UserID))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return user, http.StatusOK, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" || !claimIsTrue(claims["email_verified"]) {
+		return nil, http.StatusBadRequest, errors.New("The identity provider didn't share a verified email address")
+	}
+	identity = &model.Identity{Provider: provider, Subject: subject, Email: email, CreatedAt: time.Now()}
+
+	var user *model.User
+	user, err = s.authStore.FindUser(user, email)
+	if err == nil {
+		if !user.IsVerified() {
+			return nil, http.StatusConflict, errors.New("An account with this email address exists, verify the address to log in with the identity provider")
+		}
+		identity.UserID = user.ID
+		if err := s.authStore.CreateIdentity(identity); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return user, http.StatusOK, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	// deleted users keep their address until they are purged
+	taken, err := s.authStore.EmailTaken(email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if taken {
+		return nil, http.StatusConflict, errors.New("An account with this email address was deleted, log in to it to restore it")
+	}
+
+	// users registered this way have no password until they reset it
+	now := time.Now()
+	user = &model.User{
+		Name:       oidcName(claims, email),
+		Email:      email,
+		CreatedAt:  now,
+		Role:       model.RoleUser,
+		VerifiedAt: &now,
+	}
+	if err := s.authStore.CreateUserWithIdentity(user, identity); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return user, http.StatusOK, nil
+}
+
+// claimIsTrue reads boolean claims, which some providers send as strings.
+func claimIsTrue(claim interface{}) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func oidcName(claims jwt.MapClaims, email string) string {
+	name, _ := claims["name"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if len([]rune(name)) > 255 {
+		name = string([]rune(name)[:255])
+	}
+	return name
+}
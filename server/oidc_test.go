@@ -0,0 +1,265 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockOIDC is an OpenID Connect provider that logs in whoever the test
+// says, checking PKCE like a real provider.
+type mockOIDC struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockOIDC{key: key, grants: make(map[string]mockGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.handleToken)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize logs the user in at the provider as if they followed the link
+// to it, returning the code it redirects back with.
+func (m *mockOIDC) authorize(t *testing.T, location *url.URL, claims jwt.MapClaims) string {
+	query := location.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	code, err := randomToken(16)
+	require.NoError(t, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grants[code] = mockGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	return code
+}
+
+func (m *mockOIDC) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != "blog-api" || secret != "mock-secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.grants[r.PostFormValue("code")]
+	delete(m.grants, r.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok || pkceChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   m.URL,
+		"aud":   "blog-api",
+		"nonce": grant.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, _ := token.SignedString(m.key)
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockOIDC(t)
+	srv.AddOIDCProvider(OIDCConfig{Name: "mock", Issuer: provider.URL, ClientID: "blog-api", ClientSecret: "mock-secret"})
+
+	// unverified accounts can't be taken over by an identity with their
+	// address, verified ones are linked
+	require.NoError(t, srv.authStore.CreateUser(&model.User{Name: "squatter", Email: "squatted@gmail.com", Role: model.RoleUser}))
+	linked := &model.LoginRequest{Email: "linked@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "linked", Email: linked.Email, Password: linked.Password, PasswordConfirm: linked.Password})
+
+	start := func() (*url.URL, *http.Cookie) {
+		c, resp := makeRequest("GET", "/v1/auth/oidc/:provider", nil, false, nil)
+		c.SetParamNames("provider")
+		c.SetParamValues("mock")
+		require.NoError(t, srv.handleOIDCLogin(c))
+		require.Equal(t, http.StatusFound, resp.Code)
+
+		location, err := url.Parse(resp.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, provider.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+		assert.Equal(t, "http://example.com/v1/auth/oidc/mock/callback", location.Query().Get("redirect_uri"))
+
+		for _, cookie := range resp.Result().Cookies() {
+			if cookie.Name == "oidc-state" {
+				return location, cookie
+			}
+		}
+		require.FailNow(t, "no oidc-state cookie")
+		return nil, nil
+	}
+	callback := func(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		c, resp := makeRequest("GET", "/v1/auth/oidc/mock/callback?"+query.Encode(), nil, false, nil)
+		c.SetParamNames("provider")
+		c.SetParamValues("mock")
+		if cookie != nil {
+			c.Request().AddCookie(cookie)
+		}
+		require.NoError(t, srv.handleOIDCCallback(c))
+		return resp
+	}
+	userID := func(email string) uint {
+		var u *model.User
+		u, err := srv.authStore.FindUser(u, email)
+		require.NoError(t, err)
+		return u.ID
+	}
+
+	tests := []struct {
+		name         string
+		claims       jwt.MapClaims
+		tamper       func(query url.Values, cookie *http.Cookie) *http.Cookie
+		expectedCode int
+	}{
+		{
+			name:         "new user",
+			claims:       jwt.MapClaims{"sub": "mock-1", "email": "oidc@gmail.com", "email_verified": true, "name": "Oidc"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "returning user",
+			claims:       jwt.MapClaims{"sub": "mock-1", "email": "changed@gmail.com", "email_verified": true},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:   "started in another browser",
+			claims: jwt.MapClaims{"sub": "mock-1"},
+			tamper: func(query url.Values, cookie *http.Cookie) *http.Cookie {
+				return nil
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:   "state of another login",
+			claims: jwt.MapClaims{"sub": "mock-1"},
+			tamper: func(query url.Values, cookie *http.Cookie) *http.Cookie {
+				query.Set("state", "forged")
+				return &http.Cookie{Name: "oidc-state", Value: "forged"}
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:   "code of another login",
+			claims: jwt.MapClaims{"sub": "mock-1"},
+			tamper: func(query url.Values, cookie *http.Cookie) *http.Cookie {
+				query.Set("code", "stolen")
+				return cookie
+			},
+			expectedCode: http.StatusBadGateway,
+		},
+		{
+			name:         "nonce of another login",
+			claims:       jwt.MapClaims{"sub": "mock-1", "nonce": "replayed"},
+			expectedCode: http.StatusBadGateway,
+		},
+		{
+			name:         "token for another client",
+			claims:       jwt.MapClaims{"sub": "mock-1", "aud": "someone-else"},
+			expectedCode: http.StatusBadGateway,
+		},
+		{
+			name:         "unverified email",
+			claims:       jwt.MapClaims{"sub": "mock-2", "email": "unverified@gmail.com", "email_verified": false},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unverified local account",
+			claims:       jwt.MapClaims{"sub": "mock-3", "email": "squatted@gmail.com", "email_verified": "true"},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "verified local account",
+			claims:       jwt.MapClaims{"sub": "mock-4", "email": linked.Email, "email_verified": true},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			location, cookie := start()
+			query := url.Values{
+				"code":  {provider.authorize(t, location, tc.claims)},
+				"state": {location.Query().Get("state")},
+			}
+			if tc.tamper != nil {
+				cookie = tc.tamper(query, cookie)
+			}
+
+			resp := callback(query, cookie)
+			assert.Equal(t, tc.expectedCode, resp.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.NotEmpty(t, resp.Header().Values("Set-Cookie"))
+			}
+		})
+	}
+
+	// the returning user logged in to the account made the first time
+	identity, err := srv.authStore.FindIdentity("mock", "mock-1")
+	require.NoError(t, err)
+	assert.Equal(t, userID("oidc@gmail.com"), identity.UserID)
+
+	identity, err = srv.authStore.FindIdentity("mock", "mock-4")
+	require.NoError(t, err)
+	assert.Equal(t, userID(linked.Email), identity.UserID)
+
+	// a state works once
+	location, cookie := start()
+	query := url.Values{"code": {provider.authorize(t, location, jwt.MapClaims{"sub": "mock-1"})}, "state": {location.Query().Get("state")}}
+	assert.Equal(t, http.StatusOK, callback(query, cookie).Code)
+	assert.Equal(t, http.StatusBadRequest, callback(query, cookie).Code)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+)
+
+const (
+	defaultPageLimit = 5
+	maxPageLimit     = 100
+)
+
+// pageParams reads the page and limit query parameters, falling back to the
+// first page of 5 items. The limit is capped at 100.
+func pageParams(c echo.Context) (int, int) {
+	pageStr := c.QueryParams().Get("page")
+	limitStr := c.QueryParams().Get("limit")
+
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return page, limit
+}
+
+// encodeCursor makes a cursor clients can pass around without depending on
+// what is inside.
+func encodeCursor(cursor *repository.PostCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*repository.PostCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := new(repository.PostCursor)
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// pageList wraps a page of a page/limit listing, linking to the pages
+// around it.
+func pageList[T any](c echo.Context, data []T, total int64, page, limit int) model.ListResponse[T] {
+	list := newList(data, total, limit)
+	list.Page = page
+	if int64(page*limit) < total {
+		list.Links.Next = listURL(c, "page", strconv.Itoa(page+1))
+	}
+	if page > 1 {
+		list.Links.Prev = listURL(c, "page", strconv.Itoa(page-1))
+	}
+	return list
+}
+
+// cursorList wraps a page of a cursor listing. Cursors only go forward, so
+// there is no link to the previous page.
+func cursorList[T any](c echo.Context, data []T, total int64, limit int, next string) model.ListResponse[T] {
+	list := newList(data, total, limit)
+	list.NextCursor = next
+	if next != "" {
+		list.Links.Next = listURL(c, "cursor", next)
+	}
+	return list
+}
+
+func newList[T any](data []T, total int64, limit int) model.ListResponse[T] {
+	if data == nil {
+		data = []T{}
+	}
+	return model.ListResponse[T]{Data: data, Total: total, Limit: limit}
+}
+
+// listURL returns the URL of the current request with one query parameter
+// replaced.
+func listURL(c echo.Context, key, value string) string {
+	u := *c.Request().URL
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	u.Scheme = c.Scheme()
+	u.Host = c.Request().Host
+	return u.String()
+}
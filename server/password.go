@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	// maxPasswordResets is how many reset emails a user gets per
+	// passwordResetTTL, so the endpoint can't be used to flood an inbox
+	maxPasswordResets = 3
+)
+
+// forgotPasswordResponse is sent whether or not the email belongs to a user,
+// so the endpoint doesn't tell who has an account.
+const forgotPasswordResponse = "If an account exists for this email address, a password reset link has been sent to it"
+
+// handleForgotPassword emails a password reset token to the user with the
+// given email address.
+func (s *Server) handleForgotPassword(c echo.Context) error {
+	r := new(model.ForgotPasswordRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	// the email is sent after responding, so the response takes as long
+	// whether or not the email belongs to a user
+	s.mailing.Add(1)
+	go func() {
+		defer s.mailing.Done()
+		if err := s.sendPasswordReset(r.Email); err != nil {
+			log.Printf("failed to send password reset: %s", err)
+		}
+	}()
+
+	return RespondWithJSON(c, http.StatusOK, forgotPasswordResponse)
+}
+
+func (s *Server) sendPasswordReset(email string) error {
+	var user *model.User
+	user, err := s.authStore.FindUser(user, email)
+	if err != nil {
+		// unknown email addresses are not an error
+		return nil
+	}
+
+	sent, err := s.authStore.CountPasswordResets(user.ID, time.Now().Add(-passwordResetTTL))
+	if err != nil {
+		return err
+	}
+	if sent >= maxPasswordResets {
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := s.authStore.CreatePasswordReset(&model.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	link := s.appURL("/v1/auth/reset-password", url.Values{"token": {token}})
+	return s.mailer.Send(&repository.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Name + ",\n\n" +
+			"Someone asked to reset the password of your account. Use this link to choose a new one:\n\n" +
+			link + "\n\n" +
+			"The link expires in an hour and works once. If you didn't ask for it, you can ignore this email.\n",
+	})
+}
+
+// handleCheckPasswordReset tells whether a password reset token can still be
+// used, before the user chooses a new password.
+func (s *Server) handleCheckPasswordReset(c echo.Context) error {
+	if _, err := s.authStore.FindPasswordReset(hashToken(c.QueryParam("token"))); err != nil {
+		return respondWithPasswordResetError(c, err)
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}
+
+// handleResetPassword sets a new password with a password reset token, signs
+// the user out everywhere and revokes their API keys.
+func (s *Server) handleResetPassword(c echo.Context) error {
+	r := new(model.ResetPasswordRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), 10)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	userID, err := s.authStore.ResetPassword(hashToken(r.Token), string(hash))
+	if err != nil {
+		return respondWithPasswordResetError(c, err)
+	}
+
+	if err := s.sessionStore.RevokeUserSessions(userID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := s.authStore.DeleteUserAPIKeys(userID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}
+
+func respondWithPasswordResetError(c echo.Context, err error) error {
+	if errors.Is(err, repository.ErrInvalidPasswordReset) {
+		return RespondWithError(c, http.StatusBadRequest, "The password reset link is invalid or has expired")
+	}
+	return RespondWithError(c, http.StatusInternalServerError, err.Error())
+}
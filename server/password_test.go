@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordReset(t *testing.T) {
+	cred := &model.LoginRequest{Email: "forgetful@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "forgetful", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+
+	forgot := func(email string) string {
+		c, resp := makeRequest("POST", "/v1/auth/forgot-password", model.ForgotPasswordRequest{Email: email}, false, nil)
+		require.NoError(t, srv.handleForgotPassword(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+		srv.mailing.Wait()
+		return resp.Body.String()
+	}
+
+	// the response doesn't tell whether the account exists
+	assert.Equal(t, forgot("nobody@gmail.com"), forgot(cred.Email))
+	token := lastMailToken(t, cred.Email, "reset-password")
+
+	c, resp := makeRequest("GET", "/v1/auth/reset-password?token="+token, nil, false, nil)
+	require.NoError(t, srv.handleCheckPasswordReset(c))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// signed in before the reset
+	session, _ := makeRequest("GET", "/v1/user/me", nil, true, cred)
+
+	tests := []struct {
+		name         string
+		body         model.ResetPasswordRequest
+		expectedCode int
+	}{
+		{
+			name:         "passwords do not match",
+			body:         model.ResetPasswordRequest{Token: token, Password: "87654321", PasswordConfirm: "87654320"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unknown token",
+			body:         model.ResetPasswordRequest{Token: token + "x", Password: "87654321", PasswordConfirm: "87654321"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "reset",
+			body:         model.ResetPasswordRequest{Token: token, Password: "87654321", PasswordConfirm: "87654321"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "tokens work once",
+			body:         model.ResetPasswordRequest{Token: token, Password: "11111111", PasswordConfirm: "11111111"},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, resp := makeRequest("POST", "/v1/auth/reset-password", tc.body, false, nil)
+			require.NoError(t, srv.handleResetPassword(c))
+			assert.Equal(t, tc.expectedCode, resp.Code)
+		})
+	}
+
+	// the reset signed the user out
+	err := srv.AuthenticateUser(srv.handleGetMe)(session)
+	if he, ok := err.(*echo.HTTPError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusUnauthorized, he.Code)
+	}
+
+	c, resp = makeRequest("POST", "/v1/auth/login", cred, false, nil)
+	require.NoError(t, srv.handleLogin(c))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	c, resp = makeRequest("POST", "/v1/auth/login", &model.LoginRequest{Email: cred.Email, Password: "87654321"}, false, nil)
+	require.NoError(t, srv.handleLogin(c))
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	cred := &model.LoginRequest{Email: "changer@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "changer", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+
+	current, other := bearerToken(cred), bearerToken(cred)
+	request := func(method, route string, body interface{}, cookie *http.Cookie) (echo.Context, *httptest.ResponseRecorder) {
+		c, resp := makeRequest(method, route, body, false, nil)
+		c.Request().AddCookie(cookie)
+		withCSRF(c.Request())
+		return c, resp
+	}
+
+	tests := []struct {
+		name         string
+		body         model.ChangePasswordRequest
+		expectedCode int
+	}{
+		{
+			name:         "passwords do not match",
+			body:         model.ChangePasswordRequest{CurrentPassword: cred.Password, Password: "87654321", PasswordConfirm: "87654320"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "wrong current password",
+			body:         model.ChangePasswordRequest{CurrentPassword: "11111111", Password: "87654321", PasswordConfirm: "87654321"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "changed",
+			body:         model.ChangePasswordRequest{CurrentPassword: cred.Password, Password: "87654321", PasswordConfirm: "87654321"},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, resp := request("PUT", "/v1/user/password", tc.body, current)
+			require.NoError(t, srv.AuthenticateUser(srv.handleChangePassword)(c))
+			assert.Equal(t, tc.expectedCode, resp.Code)
+		})
+	}
+
+	// the session that changed the password stays, the others end
+	c, resp := request("GET", "/v1/user/me", nil, current)
+	require.NoError(t, srv.AuthenticateUser(srv.handleGetMe)(c))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	c, _ = request("GET", "/v1/user/me", nil, other)
+	err := srv.AuthenticateUser(srv.handleGetMe)(c)
+	if he, ok := err.(*echo.HTTPError); assert.True(t, ok) {
+		assert.Equal(t, http.StatusUnauthorized, he.Code)
+	}
+
+	c, resp = makeRequest("POST", "/v1/auth/login", &model.LoginRequest{Email: cred.Email, Password: "87654321"}, false, nil)
+	require.NoError(t, srv.handleLogin(c))
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
@@ -1,22 +1,27 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
 )
 
 func (s *Server) RegisterPostRoutes(g *echo.Group) {
 	router := g.Group("/posts")
-	router.Use(AuthenticateUser)
-	router.POST("/", s.handleCreatePost)
-	router.GET("/:id", s.handleGetPost)
-	router.PUT("/:id", s.handleUpdatePost)
-	router.DELETE("/:id", s.handleDeletePost)
-	router.GET("/", s.handleExplorePosts)
+	router.Use(s.AuthenticateUser)
+	s.allowAPIKey(router.POST("/", s.handleCreatePost), model.ScopePostsWrite)
+	s.allowAPIKey(router.GET("/search", s.handleSearchPosts), model.ScopePostsRead)
+	s.allowAPIKey(router.GET("/by-slug/:slug", s.handleGetPostBySlug), model.ScopePostsRead)
+	s.allowAPIKey(router.GET("/:id", s.handleGetPost), model.ScopePostsRead)
+	s.allowAPIKey(router.PUT("/:id", s.handleUpdatePost), model.ScopePostsWrite)
+	s.allowAPIKey(router.DELETE("/:id", s.handleDeletePost), model.ScopePostsWrite)
+	s.allowAPIKey(router.GET("/", s.handleExplorePosts), model.ScopePostsRead)
 }
 
 func (s *Server) handleCreatePost(c echo.Context) error {
@@ -36,19 +41,47 @@ func (s *Server) handleCreatePost(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
+	user, err := s.currentUser(c)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if !user.IsVerified() {
+		return RespondWithError(c, http.StatusForbidden, "Verify your email address before posting")
+	}
+
+	media, err := s.findPostMedia(c, r.PostMedia())
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
 	// create a Post
 	p := model.Post{
-		UserID:    uint(userID),
-		Title:     r.Title,
-		Content:   r.Content,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		UserID:        uint(userID),
+		Title:         r.Title,
+		Content:       r.Content,
+		ContentFormat: r.ContentFormat,
+		Tags:          r.PostTags(),
+		Media:         media,
+		Status:        r.Status,
+		PublishAt:     r.PublishAt,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	if p.Status == "" {
+		p.Status = model.PostStatusPublished
+	}
+	if p.ContentFormat == "" {
+		p.ContentFormat = model.ContentFormatPlain
+	}
+	if p.IsPublished() {
+		p.PublishAt = &p.CreatedAt
 	}
 
 	if err := s.postStore.CreatePost(&p); err != nil {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
+	s.renderPost(&p)
 	return RespondWithJSON(c, http.StatusCreated, p)
 }
 
@@ -59,21 +92,54 @@ func (s *Server) handleGetPost(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
 	}
 
-	var post model.Post
-	p, err := s.postStore.FindPost(&post, postID)
+	p, err := s.findVisiblePost(c, postID)
 	if err != nil {
 		return RespondWithError(c, http.StatusNotFound, err.Error())
 	}
 
+	s.renderPost(p)
 	return RespondWithJSON(c, http.StatusOK, p)
 }
 
-func (s *Server) handleUpdatePost(c echo.Context) error {
+// handleGetPostBySlug responds with the post using the slug. A slug the post
+// used before its title changed redirects to the current one.
+func (s *Server) handleGetPostBySlug(c echo.Context) error {
+	slug := c.Param("slug")
 
-	userID, ok := c.Get("userID").(int)
-	if !ok {
-		return RespondWithError(c, http.StatusInternalServerError, "User ID not found in context")
+	if p, err := s.postStore.FindPostBySlug(slug); err == nil {
+		if p.IsPublished() || s.authorize(c, p) == nil {
+			s.renderPost(p)
+			return RespondWithJSON(c, http.StatusOK, p)
+		}
+		return RespondWithError(c, http.StatusNotFound, "not existing/valid slug")
 	}
+
+	p, err := s.postStore.FindPostByOldSlug(slug)
+	if err != nil || (!p.IsPublished() && s.authorize(c, p) != nil) {
+		return RespondWithError(c, http.StatusNotFound, "not existing/valid slug")
+	}
+
+	return c.Redirect(http.StatusMovedPermanently, "/v1/posts/by-slug/"+url.PathEscape(p.Slug))
+}
+
+// findVisiblePost finds a post the user of the request is allowed to see.
+// Posts that aren't published yet or anymore look like they don't exist to
+// anyone but their author.
+func (s *Server) findVisiblePost(c echo.Context, postID int) (*model.Post, error) {
+	var post *model.Post
+	post, err := s.postStore.FindPost(post, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !post.IsPublished() && s.authorize(c, post) != nil {
+		return nil, errors.New("not existing/valid postId")
+	}
+	return post, nil
+}
+
+func (s *Server) handleUpdatePost(c echo.Context) error {
+
 	postID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
@@ -84,17 +150,37 @@ func (s *Server) handleUpdatePost(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
 	var post *model.Post
 	post, err = s.postStore.FindPost(post, postID)
 	if err != nil {
 		return RespondWithError(c, http.StatusNotFound, err.Error())
 	}
 
+	if err := s.authorize(c, post); err != nil {
+		return RespondWithError(c, http.StatusForbidden, err.Error())
+	}
+
+	media, err := s.findPostMedia(c, r.PostMedia())
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
 	p := model.Post{
-		UserID:    uint(userID),
-		Title:     r.Title,
-		Content:   r.Content,
-		UpdatedAt: time.Now(),
+		Title:         r.Title,
+		Content:       r.Content,
+		ContentFormat: r.ContentFormat,
+		Tags:          r.PostTags(),
+		Media:         media,
+		Status:        r.Status,
+		PublishAt:     r.PublishAt,
+		UpdatedAt:     time.Now(),
+	}
+	if p.IsPublished() && !post.IsPublished() {
+		p.PublishAt = &p.UpdatedAt
 	}
 
 	updated, err := s.postStore.UpdatePost(post, &p)
@@ -102,6 +188,7 @@ func (s *Server) handleUpdatePost(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
+	s.renderPost(updated)
 	return RespondWithJSON(c, http.StatusOK, updated)
 }
 
@@ -112,6 +199,16 @@ func (s *Server) handleDeletePost(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, "Provide postid")
 	}
 
+	var post *model.Post
+	post, err = s.postStore.FindPost(post, postID)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.authorize(c, post); err != nil {
+		return RespondWithError(c, http.StatusForbidden, err.Error())
+	}
+
 	if err = s.postStore.DeletePost(postID); err != nil {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
@@ -119,25 +216,61 @@ func (s *Server) handleDeletePost(c echo.Context) error {
 	return RespondWithJSON(c, http.StatusNoContent, nil)
 }
 
+// handleExplorePosts pages through published posts, newest first. Passing
+// ?cursor= (empty for the first page) switches from page/limit to cursor
+// pagination, which doesn't skip or repeat posts when new ones are created
+// meanwhile.
 func (s *Server) handleExplorePosts(c echo.Context) error {
-	pageStr := c.QueryParams().Get("page")
-	limitStr := c.QueryParams().Get("limit")
+	page, limit := pageParams(c)
+	filter := repository.PostFilter{
+		Status: model.PostStatusPublished,
+		Tag:    model.NormalizeTag(c.QueryParams().Get("tag")),
+		Limit:  limit,
+		Offset: (page - 1) * limit,
+	}
 
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
+	total, err := s.postStore.CountPosts(filter)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 {
-		limit = 5
+
+	if _, ok := c.QueryParams()["cursor"]; ok {
+		return s.explorePostsAfter(c, filter, total)
 	}
 
-	offset := (page - 1) * limit
+	posts, err := s.postStore.FindPosts(filter)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	s.renderPost(posts...)
+	return RespondWithList(c, pageList(c, posts, total, page, limit))
+}
 
-	posts, err := s.postStore.FindPosts(limit, offset)
+func (s *Server) explorePostsAfter(c echo.Context, filter repository.PostFilter, total int64) error {
+	if cursor := c.QueryParams().Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return RespondWithError(c, http.StatusBadRequest, "Invalid cursor")
+		}
+		filter.After = after
+	}
+
+	// fetch one more post than asked for to know if there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	posts, err := s.postStore.FindPosts(filter)
 	if err != nil {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
-	return RespondWithJSON(c, http.StatusOK, posts)
+	next := ""
+	if len(posts) > limit {
+		posts = posts[:limit]
+		last := posts[limit-1]
+		next = encodeCursor(&repository.PostCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	s.renderPost(posts...)
+	return RespondWithList(c, cursorList(c, posts, total, limit, next))
 }
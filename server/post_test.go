@@ -3,14 +3,19 @@ package server
 import (
 	"encoding/json"
 	"io"
-	"log"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandleCreatePost(t *testing.T) {
@@ -58,6 +63,17 @@ func TestHandleCreatePost(t *testing.T) {
 			expectedErrorDesc: "",
 			expectedCode:      http.StatusBadRequest,
 		},
+		{
+			// invalid tag
+			method:            "POST",
+			route:             "/v1/posts/",
+			body:              &model.CreatePostRequest{Title: "Books", Content: "Here are the most influential books of all time ....", Tags: []string{"no spaces"}},
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
+			expectedError:     true,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusBadRequest,
+		},
 		{
 			// success
 			method:            "POST",
@@ -70,7 +86,7 @@ func TestHandleCreatePost(t *testing.T) {
 			expectedCode:      http.StatusCreated,
 		},
 		{
-			// duplicate title
+			// duplicate title gets its own slug
 			method:            "POST",
 			route:             "/v1/posts/",
 			body:              &model.CreatePostRequest{Title: "Books", Content: "Here are the most influential books of all time ...."},
@@ -78,7 +94,7 @@ func TestHandleCreatePost(t *testing.T) {
 			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
 			expectedError:     true,
 			expectedErrorDesc: "",
-			expectedCode:      http.StatusBadRequest,
+			expectedCode:      http.StatusCreated,
 		},
 	}
 
@@ -86,7 +102,7 @@ func TestHandleCreatePost(t *testing.T) {
 		c, resp := makeRequest(test.method, test.route, test.body, test.authReq, test.cred)
 
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleCreatePost)(c)
+			err := srv.AuthenticateUser(srv.handleCreatePost)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -99,7 +115,7 @@ func TestHandleCreatePost(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleCreatePost)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 	}
@@ -173,7 +189,7 @@ func TestHandleGetPost(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues(test.postId)
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleGetPost)(c)
+			err := srv.AuthenticateUser(srv.handleGetPost)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -186,7 +202,7 @@ func TestHandleGetPost(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleGetPost)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleGetPost)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 
@@ -201,6 +217,12 @@ func TestHandleGetPost(t *testing.T) {
 }
 
 func TestHandleUpdatePost(t *testing.T) {
+	createTestUser(t, model.RegisterRequest{
+		Name:            "jane",
+		Email:           "janedoe@gmail.com",
+		Password:        "12345678",
+		PasswordConfirm: "12345678",
+	})
 
 	tests := []struct {
 		method            string
@@ -249,6 +271,18 @@ func TestHandleUpdatePost(t *testing.T) {
 			expectedErrorDesc: "",
 			expectedCode:      http.StatusNotFound,
 		},
+		{
+			// not the owner of the post
+			method:            "PUT",
+			route:             "/v1/posts/:id",
+			postId:            "1",
+			body:              &model.UpdatePostRequest{Title: "Hijacked", Content: "Updated content has no meaning"},
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "janedoe@gmail.com", Password: "12345678"},
+			expectedError:     true,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusForbidden,
+		},
 		{
 			// success
 			method:            "PUT",
@@ -269,7 +303,7 @@ func TestHandleUpdatePost(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues(test.postId)
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleUpdatePost)(c)
+			err := srv.AuthenticateUser(srv.handleUpdatePost)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -282,7 +316,7 @@ func TestHandleUpdatePost(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleUpdatePost)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 
@@ -346,6 +380,18 @@ func TestHandleDeletePost(t *testing.T) {
 			expectedErrorDesc: "",
 			expectedCode:      http.StatusBadRequest,
 		},
+		{
+			// not the owner of the post
+			method:            "DELETE",
+			route:             "/v1/posts/:id",
+			postId:            "1",
+			body:              nil,
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "janedoe@gmail.com", Password: "12345678"},
+			expectedError:     true,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusForbidden,
+		},
 		{
 			// success
 			method:            "DELETE",
@@ -366,7 +412,7 @@ func TestHandleDeletePost(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues(test.postId)
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleDeletePost)(c)
+			err := srv.AuthenticateUser(srv.handleDeletePost)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -379,7 +425,7 @@ func TestHandleDeletePost(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleDeletePost)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleDeletePost)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 	}
@@ -422,7 +468,7 @@ func TestHandleExplorePosts(t *testing.T) {
 		c, resp := makeRequest(test.method, test.route, nil, test.authReq, test.cred)
 
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleExplorePosts)(c)
+			err := srv.AuthenticateUser(srv.handleExplorePosts)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -435,17 +481,309 @@ func TestHandleExplorePosts(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleExplorePosts)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 
 		if !test.expectedError {
-			post := []model.Post{}
+			list := model.ListResponse[model.Post]{}
 			responseBytes, _ := io.ReadAll(resp.Result().Body)
-			_ = json.Unmarshal(responseBytes, &post)
-			log.Println(post)
-			// uintPostId, _ := strconv.ParseUint(test.postId, 0, 64)
-			// assert.Equal(t, uint(uintPostId), post.ID)
+			require.NoError(t, json.Unmarshal(responseBytes, &list))
+			// the total counts every published post, not only the page
+			total, err := srv.postStore.CountPosts(repository.PostFilter{Status: model.PostStatusPublished})
+			require.NoError(t, err)
+			assert.Equal(t, total, list.Total)
+			assert.LessOrEqual(t, len(list.Data), defaultPageLimit)
+			assert.Equal(t, 1, list.Page)
+		}
+	}
+
+	// filter by tag
+	cred := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Tagged", Content: "Go news", Tags: []string{"Go", "news", "go"}}, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	c, resp = makeRequest("GET", "/v1/posts/?tag=GO", nil, true, cred)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c)) {
+		list := model.ListResponse[model.Post]{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, int64(1), list.Total)
+		assert.Equal(t, "Tagged", list.Data[0].Title)
+		assert.Len(t, list.Data[0].Tags, 2)
+	}
+
+	// cursor pagination walks through the same posts as page/limit
+	c, resp = makeRequest("GET", "/v1/posts/?limit=100", nil, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c))
+	list := model.ListResponse[model.Post]{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	require.NotEmpty(t, list.Data)
+	all := list.Data
+
+	// page/limit links
+	c, resp = makeRequest("GET", "/v1/posts/?limit=1&page=2", nil, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c))
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+	assert.Equal(t, "http://example.com/v1/posts/?limit=1&page=1", list.Links.Prev)
+	assert.Contains(t, resp.Header().Get("Link"), `<http://example.com/v1/posts/?limit=1&page=1>; rel="prev"`)
+	if len(all) > 2 {
+		assert.Equal(t, "http://example.com/v1/posts/?limit=1&page=3", list.Links.Next)
+	}
+
+	walked := []model.Post{}
+	cursor := ""
+	for i := 0; i <= len(all); i++ {
+		c, resp = makeRequest("GET", "/v1/posts/?limit=1&cursor="+cursor, nil, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		page := model.ListResponse[model.Post]{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &page))
+		assert.Equal(t, int64(len(all)), page.Total)
+		walked = append(walked, page.Data...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	require.Len(t, walked, len(all))
+	for i := range all {
+		assert.Equal(t, all[i].ID, walked[i].ID)
+	}
+
+	// invalid cursor
+	c, resp = makeRequest("GET", "/v1/posts/?cursor=oops", nil, true, cred)
+	if assert.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c)) {
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	}
+}
+
+func TestPostLifecycle(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	jane := &model.LoginRequest{Email: "janedoe@gmail.com", Password: "12345678"}
+	inAnHour := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		body         *model.CreatePostRequest
+		expectedCode int
+	}{
+		{
+			// unknown status
+			body:         &model.CreatePostRequest{Title: "Lifecycle", Content: "Not yet", Status: "hidden"},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// scheduled without publish time
+			body:         &model.CreatePostRequest{Title: "Lifecycle", Content: "Not yet", Status: model.PostStatusScheduled},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// publish time in the past
+			body:         &model.CreatePostRequest{Title: "Lifecycle", Content: "Not yet", Status: model.PostStatusScheduled, PublishAt: &time.Time{}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// publish time without scheduling
+			body:         &model.CreatePostRequest{Title: "Lifecycle", Content: "Not yet", Status: model.PostStatusDraft, PublishAt: &inAnHour},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		c, resp := makeRequest("POST", "/v1/posts/", test.body, true, john)
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c)) {
+			assert.Equal(t, test.expectedCode, resp.Code)
+		}
+	}
+
+	create := func(body *model.CreatePostRequest) model.Post {
+		c, resp := makeRequest("POST", "/v1/posts/", body, true, john)
+		require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+		require.Equal(t, http.StatusCreated, resp.Code)
+
+		var post model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+		return post
+	}
+	getCode := func(post model.Post, cred *model.LoginRequest) int {
+		c, resp := makeRequest("GET", "/v1/posts/:id", nil, true, cred)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(int(post.ID)))
+		require.NoError(t, srv.AuthenticateUser(srv.handleGetPost)(c))
+		return resp.Code
+	}
+	explored := func(post model.Post) bool {
+		c, resp := makeRequest("GET", "/v1/posts/?limit=100", nil, true, jane)
+		require.NoError(t, srv.AuthenticateUser(srv.handleExplorePosts)(c))
+
+		list := model.ListResponse[model.Post]{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+		for _, p := range list.Data {
+			if p.ID == post.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	// drafts are only visible to their author
+	draft := create(&model.CreatePostRequest{Title: "Draft", Content: "Not yet", Status: model.PostStatusDraft})
+	assert.Equal(t, model.PostStatusDraft, draft.Status)
+	assert.Equal(t, http.StatusOK, getCode(draft, john))
+	assert.Equal(t, http.StatusNotFound, getCode(draft, jane))
+	assert.False(t, explored(draft))
+
+	// scheduled posts show up once the scheduler published them
+	scheduled := create(&model.CreatePostRequest{Title: "Scheduled", Content: "Soon", Status: model.PostStatusScheduled, PublishAt: &inAnHour})
+	assert.Equal(t, http.StatusNotFound, getCode(scheduled, jane))
+	assert.False(t, explored(scheduled))
+
+	published, err := srv.postStore.PublishDuePosts(inAnHour.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), published)
+	assert.Equal(t, http.StatusOK, getCode(scheduled, jane))
+	assert.True(t, explored(scheduled))
+
+	// publishing a draft
+	c, resp := makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{Status: model.PostStatusPublished}, true, john)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(int(draft.ID)))
+	require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, http.StatusOK, getCode(draft, jane))
+	assert.True(t, explored(draft))
+}
+
+func TestPostSlugs(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	jane := &model.LoginRequest{Email: "janedoe@gmail.com", Password: "12345678"}
+
+	create := func(title string, cred *model.LoginRequest) model.Post {
+		c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: title, Content: "Slugged"}, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+		require.Equal(t, http.StatusCreated, resp.Code)
+
+		var post model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+		return post
+	}
+	getBySlug := func(slug string) *httptest.ResponseRecorder {
+		c, resp := makeRequest("GET", "/v1/posts/by-slug/:slug", nil, true, jane)
+		c.SetParamNames("slug")
+		c.SetParamValues(slug)
+		require.NoError(t, srv.AuthenticateUser(srv.handleGetPostBySlug)(c))
+		return resp
+	}
+
+	first := create("Çay ve Kahve", john)
+	assert.Equal(t, "cay-ve-kahve", first.Slug)
+
+	// the same title by another user gets a suffix
+	second := create("Çay ve kahve!", jane)
+	assert.Equal(t, "cay-ve-kahve-2", second.Slug)
+
+	third := create("東京の夏", john)
+	assert.Equal(t, "東京の夏", third.Slug)
+
+	resp := getBySlug("cay-ve-kahve-2")
+	if assert.Equal(t, http.StatusOK, resp.Code) {
+		var post model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+		assert.Equal(t, second.ID, post.ID)
+	}
+
+	assert.Equal(t, http.StatusNotFound, getBySlug("no-such-slug").Code)
+
+	// a new title moves the post to a new slug and the old one redirects
+	c, resp := makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{Title: "Tea and Coffee"}, true, john)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(int(first.ID)))
+	require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var updated model.Post
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &updated))
+	assert.Equal(t, "tea-and-coffee", updated.Slug)
+
+	resp = getBySlug("cay-ve-kahve")
+	assert.Equal(t, http.StatusMovedPermanently, resp.Code)
+	assert.Equal(t, "/v1/posts/by-slug/tea-and-coffee", resp.Header().Get("Location"))
+
+	// old slugs stay reserved for the post that used them
+	fourth := create("Çay ve Kahve", jane)
+	assert.Equal(t, "cay-ve-kahve-3", fourth.Slug)
+
+	t.Run("posts created at the same time get their own slug", func(t *testing.T) {
+		var u *model.User
+		u, err := srv.authStore.FindUser(u, john.Email)
+		require.NoError(t, err)
+
+		posts := make([]model.Post, 5)
+		errs := make([]error, len(posts))
+		var wg sync.WaitGroup
+		for i := range posts {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				posts[i] = model.Post{UserID: u.ID, Title: "Same Time", Content: "Racing", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+				errs[i] = srv.postStore.CreatePost(&posts[i])
+			}(i)
+		}
+		wg.Wait()
+
+		slugs := make(map[string]bool)
+		for i := range posts {
+			require.NoError(t, errs[i])
+			slugs[posts[i].Slug] = true
+		}
+		assert.Len(t, slugs, len(posts))
+		assert.True(t, slugs["same-time"])
+	})
+}
+
+func TestPostContent(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+
+	// long form markdown with an attempt at stored XSS
+	content := "# Heading\n\n" + strings.Repeat("Long form. ", 50) +
+		"\n\n<script>alert(1)</script><iframe src=\"https://example.com\"></iframe><img src=\"a.png\" onerror=\"alert(1)\">"
+	c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Markdown", Content: content, ContentFormat: model.ContentFormatMarkdown}, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	var post model.Post
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+	assert.Equal(t, model.ContentFormatMarkdown, post.ContentFormat)
+	assert.Contains(t, post.ContentHTML, "<h1>Heading</h1>")
+	assert.Contains(t, post.ContentHTML, `<img src="a.png"/>`)
+	assert.NotContains(t, post.ContentHTML, "<script")
+	assert.NotContains(t, post.ContentHTML, "<iframe")
+	assert.NotContains(t, post.ContentHTML, "onerror")
+
+	// switching to plain text escapes the markup and is a new revision
+	c, resp = makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{ContentFormat: model.ContentFormatPlain}, true, john)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(int(post.ID)))
+	require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var updated model.Post
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &updated))
+	assert.Equal(t, post.Revision+1, updated.Revision)
+	assert.Contains(t, updated.ContentHTML, "<p># Heading</p>")
+	assert.Contains(t, updated.ContentHTML, "&lt;script&gt;")
+
+	// unknown format and content over the limit
+	tests := []*model.CreatePostRequest{
+		{Title: "Format", Content: "Hello", ContentFormat: "html"},
+		{Title: "Too long", Content: strings.Repeat("a", model.MaxContentLength+1)},
+	}
+	for _, body := range tests {
+		c, resp := makeRequest("POST", "/v1/posts/", body, true, john)
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c)) {
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
 		}
 	}
 }
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/repository"
+)
+
+// defaultRateLimitGroup is the policy of routes outside the other groups.
+const defaultRateLimitGroup = "default"
+
+// defaultRateLimits are the policies of the route groups until SetRateLimit
+// changes them.
+func defaultRateLimits() map[string]repository.RateLimit {
+	return map[string]repository.RateLimit{
+		"auth":                {Requests: 20, Period: time.Minute},
+		"posts":               {Requests: 120, Period: time.Minute},
+		"user":                {Requests: 60, Period: time.Minute},
+		defaultRateLimitGroup: {Requests: 300, Period: time.Minute},
+	}
+}
+
+// SetRateLimit changes the policy of a route group, such as auth for the
+// routes under /v1/auth, or default for routes without a policy of their own.
+// A policy without requests turns rate limiting off for the group. Policies
+// are set before the server starts.
+func (s *Server) SetRateLimit(group string, limit repository.RateLimit) {
+	s.rateLimits[group] = limit
+}
+
+// RateLimit limits the requests of each client to the policy of the route
+// group, counting the requests of logged in users by user and the others by
+// address.
+func (s *Server) RateLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		group := s.rateLimitGroup(c.Path())
+		limit := s.rateLimits[group]
+		if limit.Requests <= 0 || limit.Period <= 0 {
+			return next(c)
+		}
+
+		result, err := s.rateLimitStore.TakeToken(group+":"+s.rateLimitClient(c), limit, time.Now())
+		if err != nil {
+			// requests are refused rather than let through unlimited, which
+			// would open logins and password resets to guessing
+			log.Printf("[ratelimit] error: %s", err)
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "The service is unavailable, try again later")
+		}
+
+		header := c.Response().Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", limit.Requests, ceilSeconds(limit.Period)))
+		if !result.Allowed {
+			header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests, try again later")
+		}
+
+		return next(c)
+	}
+}
+
+// rateLimitGroup returns the group of a route such as /v1/posts/:id.
+func (s *Server) rateLimitGroup(path string) string {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(segments) > 1 {
+		if _, ok := s.rateLimits[segments[1]]; ok {
+			return segments[1]
+		}
+	}
+	return defaultRateLimitGroup
+}
+
+// rateLimitClient identifies the client by the user of a valid access token,
+// so users behind the same address don't share a bucket, or by address.
+func (s *Server) rateLimitClient(c echo.Context) string {
+	if token, fromCookie := accessToken(c); token != "" {
+		if !fromCookie && strings.HasPrefix(token, apiKeyPrefix) {
+			if apiKey, err := s.findAPIKey(c, token); err == nil {
+				return "user:" + fmt.Sprint(apiKey.UserID)
+			}
+			return "ip:" + s.clientIP(c)
+		}
+		if claims, err := s.keys.ValidateToken(token); err == nil && claims["sub"] != nil {
+			return "user:" + fmt.Sprint(claims["sub"])
+		}
+	}
+	return "ip:" + s.clientIP(c)
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// pruneRateLimits forgets buckets unused for longer than the longest period,
+// which are full again and no different from new ones.
+func (s *Server) pruneRateLimits() {
+	var longest time.Duration
+	for _, limit := range s.rateLimits {
+		if limit.Period > longest {
+			longest = limit.Period
+		}
+	}
+
+	n, err := s.rateLimitStore.PruneRateLimits(time.Now().Add(-longest))
+	if err != nil {
+		log.Printf("[scheduler] error: pruning rate limits: %s", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[scheduler] pruned %d rate limit buckets", n)
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStores(t *testing.T) {
+	stores := map[string]repository.RateLimitStore{
+		"postgres": srv.rateLimitStore,
+		"memory":   repository.NewMemoryRateLimitRepository(),
+	}
+	// a token every minute, in the past so pruning leaves other buckets
+	limit := repository.RateLimit{Requests: 2, Period: 2 * time.Minute}
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		at         time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+		reset      time.Duration
+	}{
+		{name: "new bucket", at: 0, allowed: true, remaining: 1, reset: time.Minute},
+		{name: "last token", at: 0, allowed: true, remaining: 0, reset: 2 * time.Minute},
+		{name: "empty", at: 0, allowed: false, remaining: 0, retryAfter: time.Minute, reset: 2 * time.Minute},
+		{name: "half refilled", at: 30 * time.Second, allowed: false, remaining: 0, retryAfter: 30 * time.Second, reset: 90 * time.Second},
+		{name: "refilled", at: time.Minute, allowed: true, remaining: 0, reset: 2 * time.Minute},
+		{name: "full", at: time.Hour, allowed: true, remaining: 1, reset: time.Minute},
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range tests {
+				result, err := store.TakeToken("test:"+name, limit, start.Add(tc.at))
+				require.NoError(t, err)
+				assert.Equal(t, tc.allowed, result.Allowed, tc.name)
+				assert.Equal(t, tc.remaining, result.Remaining, tc.name)
+				assert.InDelta(t, tc.retryAfter, result.RetryAfter, float64(time.Millisecond), tc.name)
+				assert.InDelta(t, tc.reset, result.Reset, float64(time.Millisecond), tc.name)
+			}
+
+			n, err := store.PruneRateLimits(start.Add(time.Hour + time.Second))
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	cred := &model.LoginRequest{Email: "limited@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "limited", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+
+	srv.SetRateLimit("auth", repository.RateLimit{Requests: 2, Period: time.Minute})
+	t.Cleanup(func() { srv.SetRateLimit("auth", defaultRateLimits()["auth"]) })
+
+	request := func(path, ip string, cred *model.LoginRequest) (int, http.Header) {
+		c, resp := makeRequest("GET", path, nil, cred != nil, cred)
+		c.SetPath(path)
+		c.Request().RemoteAddr = ip + ":1234"
+		err := srv.RateLimit(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})(c)
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he.Code, resp.Header()
+		}
+		require.NoError(t, err)
+		return resp.Code, resp.Header()
+	}
+
+	t.Run("by address", func(t *testing.T) {
+		code, header := request("/v1/auth/login", "198.51.100.1", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "2", header.Get("RateLimit-Limit"))
+		assert.Equal(t, "1", header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", header.Get("RateLimit-Reset"))
+		assert.Equal(t, "2;w=60", header.Get("RateLimit-Policy"))
+
+		code, header = request("/v1/auth/login", "198.51.100.1", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "0", header.Get("RateLimit-Remaining"))
+
+		code, header = request("/v1/auth/login", "198.51.100.1", nil)
+		assert.Equal(t, http.StatusTooManyRequests, code)
+		assert.Equal(t, "0", header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", header.Get("Retry-After"))
+
+		// other addresses and groups have buckets of their own
+		code, _ = request("/v1/auth/login", "198.51.100.2", nil)
+		assert.Equal(t, http.StatusOK, code)
+		code, header = request("/v1/posts/:id", "198.51.100.1", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "120", header.Get("RateLimit-Limit"))
+	})
+
+	t.Run("by user", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			code, _ := request("/v1/auth/logout", "198.51.100.1", cred)
+			assert.Equal(t, http.StatusOK, code)
+		}
+		code, _ := request("/v1/auth/logout", "198.51.100.3", cred)
+		assert.Equal(t, http.StatusTooManyRequests, code)
+	})
+
+	t.Run("routes without a group of their own", func(t *testing.T) {
+		code, header := request("/v1/admin/users", "198.51.100.1", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "300", header.Get("RateLimit-Limit"))
+	})
+
+	t.Run("turned off", func(t *testing.T) {
+		srv.SetRateLimit("auth", repository.RateLimit{})
+		for i := 0; i < 3; i++ {
+			code, header := request("/v1/auth/login", "198.51.100.1", nil)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Empty(t, header.Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("store errors refuse requests", func(t *testing.T) {
+		store := srv.rateLimitStore
+		srv.rateLimitStore = failingRateLimitStore{}
+		t.Cleanup(func() { srv.rateLimitStore = store })
+
+		code, _ := request("/v1/posts/:id", "198.51.100.1", nil)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	})
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) TakeToken(string, repository.RateLimit, time.Time) (repository.RateLimitResult, error) {
+	return repository.RateLimitResult{}, errors.New("connection refused")
+}
+
+func (failingRateLimitStore) PruneRateLimits(time.Time) (int64, error) {
+	return 0, errors.New("connection refused")
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"html"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// maxRenderedPosts bounds the number of rendered revisions kept in memory.
+const maxRenderedPosts = 1024
+
+type renderKey struct {
+	postID   uint
+	revision int
+}
+
+// contentRenderer renders post content to sanitized HTML. A revision never
+// changes once recorded, so its rendering is cached by post ID and revision.
+type contentRenderer struct {
+	markdown goldmark.Markdown
+
+	mu    sync.Mutex
+	cache map[renderKey]string
+	order []renderKey
+}
+
+func newContentRenderer() *contentRenderer {
+	return &contentRenderer{
+		// raw HTML is allowed in Markdown, sanitizeHTML removes what's unsafe
+		markdown: goldmark.New(
+			goldmark.WithExtensions(
+				// alignment as attributes, style attributes are removed
+				extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+				extension.Strikethrough,
+				extension.Linkify,
+				extension.TaskList,
+			),
+			goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+		),
+		cache: make(map[renderKey]string),
+	}
+}
+
+// renderPost sets the ContentHTML of the posts.
+func (s *Server) renderPost(posts ...*model.Post) {
+	for _, p := range posts {
+		p.ContentHTML = s.renderer.render(p)
+	}
+}
+
+func (r *contentRenderer) render(p *model.Post) string {
+	key := renderKey{postID: p.ID, revision: p.Revision}
+
+	r.mu.Lock()
+	rendered, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok {
+		return rendered
+	}
+
+	rendered = r.renderContent(p.Content, p.ContentFormat)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cache[key]; !ok {
+		if len(r.order) >= maxRenderedPosts {
+			delete(r.cache, r.order[0])
+			r.order = r.order[1:]
+		}
+		r.cache[key] = rendered
+		r.order = append(r.order, key)
+	}
+	return rendered
+}
+
+func (r *contentRenderer) renderContent(content, format string) string {
+	if format != model.ContentFormatMarkdown {
+		return renderPlain(content)
+	}
+
+	var buf bytes.Buffer
+	if err := r.markdown.Convert([]byte(content), &buf); err != nil {
+		log.Printf("failed to render markdown: %s", err)
+		return renderPlain(content)
+	}
+
+	sanitized, err := sanitizeHTML(buf.String())
+	if err != nil {
+		log.Printf("failed to sanitize markdown: %s", err)
+		return renderPlain(content)
+	}
+	return sanitized
+}
+
+// renderPlain escapes plain text, turning blank line separated blocks into
+// paragraphs and other line breaks into <br>.
+func renderPlain(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+
+	var b strings.Builder
+	for _, block := range strings.Split(content, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+		lines := strings.Split(block, "\n")
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+		b.WriteString("<p>" + strings.Join(lines, "<br>\n") + "</p>\n")
+	}
+	return b.String()
+}
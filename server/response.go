@@ -1,9 +1,13 @@
 package server
 
 import (
+	"fmt"
 	"log"
+	"net/http"
+	"strings"
 
 	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
 )
 
 func RespondWithError(c echo.Context, code int, err string) error {
@@ -14,3 +18,20 @@ func RespondWithError(c echo.Context, code int, err string) error {
 func RespondWithJSON(c echo.Context, code int, payload interface{}) error {
 	return c.JSON(code, payload)
 }
+
+// RespondWithList sends a list envelope, repeating its links in an RFC 5988
+// Link header.
+func RespondWithList[T any](c echo.Context, list model.ListResponse[T]) error {
+	var links []string
+	if list.Links.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, list.Links.Next))
+	}
+	if list.Links.Prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, list.Links.Prev))
+	}
+	if len(links) > 0 {
+		c.Response().Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	return RespondWithJSON(c, http.StatusOK, list)
+}
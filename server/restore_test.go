@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestAccountRestore(t *testing.T) {
+	cred := &model.LoginRequest{Email: "regretful@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "regretful", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+	other := &model.LoginRequest{Email: "opportunist@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "opportunist", Email: other.Email, Password: other.Password, PasswordConfirm: other.Password})
+
+	create := func(title string) int {
+		c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: title, Content: "Second thoughts"}, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+		require.Equal(t, http.StatusCreated, resp.Code)
+		var post model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+		return int(post.ID)
+	}
+	exists := func(postID int) bool {
+		var post *model.Post
+		_, err := srv.postStore.FindPost(post, postID)
+		return err == nil
+	}
+	login := func() int {
+		c, resp := makeRequest("POST", "/v1/auth/login", cred, false, nil)
+		c.Request().RemoteAddr = "198.51.100.25:1234"
+		require.NoError(t, srv.handleLogin(c))
+		return resp.Code
+	}
+	deleteAccount := func() {
+		c, resp := makeRequest("DELETE", "/v1/user/", nil, true, cred)
+		require.NoError(t, srv.AuthenticateUser(srv.handleDeleteProfile)(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.NotEmpty(t, resp.Header().Values("Set-Cookie"), "session cookies are cleared")
+	}
+	comments := func(postID int) int64 {
+		n, err := srv.commentStore.CountComments(postID, false)
+		require.NoError(t, err)
+		return n
+	}
+	revisions := func(postID int) int64 {
+		n, err := srv.revisionStore.CountRevisions(postID)
+		require.NoError(t, err)
+		return n
+	}
+	restorePost := func(postID int) int {
+		c, resp := makeRequest("POST", "/v1/admin/posts/:id/restore", nil, false, nil)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(postID))
+		require.NoError(t, srv.handleRestorePost(c))
+		return resp.Code
+	}
+
+	kept := create("Kept")
+	deleted := create("Deleted first")
+	c, resp := makeRequest("DELETE", "/v1/posts/:id", nil, true, cred)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(deleted))
+	require.NoError(t, srv.AuthenticateUser(srv.handleDeletePost)(c))
+	require.Equal(t, http.StatusNoContent, resp.Code)
+
+	c, resp = makeRequest("POST", "/v1/posts/:id/comments", &model.CreateCommentRequest{Content: "Never mind"}, true, cred)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(kept))
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreateComment)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+
+	t.Run("logging in restores the account", func(t *testing.T) {
+		deleteAccount()
+		assert.False(t, exists(kept))
+		assert.Zero(t, comments(kept), "comments of deleted users are hidden")
+
+		c, resp := makeRequest("PUT", "/v1/user/email", &model.ChangeEmailRequest{Email: cred.Email, CurrentPassword: other.Password}, true, other)
+		require.NoError(t, srv.AuthenticateUser(srv.handleChangeEmail)(c))
+		assert.Equal(t, http.StatusConflict, resp.Code, "deleted users keep their address")
+
+		c, resp = makeRequest("POST", "/v1/auth/register", &model.RegisterRequest{Name: "regretful", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password}, false, nil)
+		require.NoError(t, srv.handleRegister(c))
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.Contains(t, resp.Body.String(), "log in to restore it")
+
+		assert.Equal(t, http.StatusOK, login())
+		assert.True(t, exists(kept))
+		assert.Equal(t, int64(1), comments(kept))
+		assert.False(t, exists(deleted), "posts deleted before the account stay deleted")
+	})
+
+	t.Run("restoring posts", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, restorePost(deleted))
+		assert.True(t, exists(deleted))
+		assert.Equal(t, http.StatusNotFound, restorePost(deleted), "not deleted")
+
+		// posts come back with their author
+		deleteAccount()
+		assert.Equal(t, http.StatusNotFound, restorePost(kept))
+		assert.False(t, exists(kept))
+	})
+
+	t.Run("purged after the restore window", func(t *testing.T) {
+		_, err := srv.userStore.FindDeletedUserByEmail(cred.Email, time.Now().Add(time.Minute))
+		assert.Error(t, err, "deleted before the window")
+
+		_, err = srv.userStore.PurgeDeletedUsers(time.Now().Add(time.Second))
+		require.NoError(t, err)
+
+		_, err = srv.userStore.FindDeletedUserByEmail(cred.Email, time.Time{})
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Equal(t, http.StatusUnauthorized, login())
+		for _, postID := range []int{kept, deleted} {
+			assert.Equal(t, http.StatusNotFound, restorePost(postID))
+			assert.Zero(t, revisions(postID), "the rows of purged posts are gone")
+		}
+		assert.Zero(t, comments(kept))
+	})
+
+	t.Run("deleted posts are purged after the restore window", func(t *testing.T) {
+		c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Short lived", Content: "Gone soon"}, true, other)
+		require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+		require.Equal(t, http.StatusCreated, resp.Code)
+		var post model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+		postID := int(post.ID)
+
+		c, resp = makeRequest("DELETE", "/v1/posts/:id", nil, true, other)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(postID))
+		require.NoError(t, srv.AuthenticateUser(srv.handleDeletePost)(c))
+		require.Equal(t, http.StatusNoContent, resp.Code)
+
+		srv.purgeDeleted()
+		assert.Equal(t, int64(1), revisions(postID), "kept for the restore window")
+
+		_, err := srv.postStore.PurgeDeletedPosts(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, restorePost(postID))
+		assert.Zero(t, revisions(postID))
+	})
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+func (s *Server) RegisterRevisionRoutes(g *echo.Group) {
+	router := g.Group("/posts/:id/revisions")
+	router.Use(s.AuthenticateUser)
+	s.allowAPIKey(router.GET("", s.handleListRevisions), model.ScopePostsRead)
+	s.allowAPIKey(router.GET("/diff", s.handleDiffRevisions), model.ScopePostsRead)
+	s.allowAPIKey(router.POST("/:rev/restore", s.handleRestoreRevision), model.ScopePostsWrite)
+}
+
+// findOwnedPost finds a post whose history the user of the request may see
+// and change. Revisions can hold content the author never published, so they
+// are private to the author.
+func (s *Server) findOwnedPost(c echo.Context) (*model.Post, int, error) {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	var post *model.Post
+	post, err = s.postStore.FindPost(post, postID)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+
+	if err := s.authorize(c, post); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	return post, http.StatusOK, nil
+}
+
+func (s *Server) handleListRevisions(c echo.Context) error {
+	post, code, err := s.findOwnedPost(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	page, limit := pageParams(c)
+
+	total, err := s.revisionStore.CountRevisions(int(post.ID))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	revisions, err := s.revisionStore.FindRevisions(int(post.ID), limit, (page-1)*limit)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	return RespondWithList(c, pageList(c, revisions, total, page, limit))
+}
+
+// handleDiffRevisions returns a unified diff from revision ?from= to
+// revision ?to=, which defaults to the current revision.
+func (s *Server) handleDiffRevisions(c echo.Context) error {
+	post, code, err := s.findOwnedPost(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	from, err := strconv.Atoi(c.QueryParams().Get("from"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide the revision to diff from")
+	}
+	to := post.Revision
+	if toStr := c.QueryParams().Get("to"); toStr != "" {
+		if to, err = strconv.Atoi(toStr); err != nil {
+			return RespondWithError(c, http.StatusBadRequest, "Provide a valid revision to diff to")
+		}
+	}
+
+	a, err := s.revisionStore.FindRevision(int(post.ID), from)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+	b, err := s.revisionStore.FindRevision(int(post.ID), to)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(revisionText(a)),
+		B:        difflib.SplitLines(revisionText(b)),
+		FromFile: "revision " + strconv.Itoa(from),
+		ToFile:   "revision " + strconv.Itoa(to),
+		Context:  3,
+	})
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, model.RevisionDiff{From: from, To: to, Diff: diff})
+}
+
+// handleRestoreRevision puts the title, content and content format of an old
+// revision back.
+// The restore is itself recorded as a new revision, so it can be undone.
+func (s *Server) handleRestoreRevision(c echo.Context) error {
+	post, code, err := s.findOwnedPost(c)
+	if err != nil {
+		return RespondWithError(c, code, err.Error())
+	}
+
+	rev, err := strconv.Atoi(c.Param("rev"))
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, "Provide revision")
+	}
+
+	revision, err := s.revisionStore.FindRevision(int(post.ID), rev)
+	if err != nil {
+		return RespondWithError(c, http.StatusNotFound, err.Error())
+	}
+
+	updated, err := s.postStore.UpdatePost(post, &model.Post{
+		Title:         revision.Title,
+		Content:       revision.Content,
+		ContentFormat: revision.ContentFormat,
+		UpdatedAt:     time.Now(),
+	})
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	s.renderPost(updated)
+	return RespondWithJSON(c, http.StatusOK, updated)
+}
+
+// revisionText is what diffs compare: the title, a blank line and the
+// content, each ending with a newline.
+func revisionText(r *model.PostRevision) string {
+	text := r.Title + "\n\n" + r.Content
+	if len(text) > 0 && text[len(text)-1] != '\n' {
+		text += "\n"
+	}
+	return text
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostRevisions(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+	jane := &model.LoginRequest{Email: "janedoe@gmail.com", Password: "12345678"}
+
+	c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "History", Content: "first line\nsecond line"}, true, john)
+	require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+	require.Equal(t, http.StatusCreated, resp.Code)
+	var post model.Post
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+	assert.Equal(t, 1, post.Revision)
+	postID := strconv.Itoa(int(post.ID))
+
+	request := func(method, route string, cred *model.LoginRequest, names []string, values []string) (echo.Context, *httptest.ResponseRecorder) {
+		c, resp := makeRequest(method, route, nil, true, cred)
+		c.SetParamNames(names...)
+		c.SetParamValues(values...)
+		return c, resp
+	}
+
+	for _, content := range []string{"first line\nsecond line changed", "first line\nsecond line changed\nthird line"} {
+		c, resp := makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{Content: content}, true, john)
+		c.SetParamNames("id")
+		c.SetParamValues(postID)
+		require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+	}
+
+	// status changes don't make revisions
+	c, resp = makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{Status: model.PostStatusArchived}, true, john)
+	c.SetParamNames("id")
+	c.SetParamValues(postID)
+	require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	tests := []struct {
+		cred         *model.LoginRequest
+		postId       string
+		expectedCode int
+	}{
+		{
+			// invalid postId value
+			cred:         john,
+			postId:       "oops",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			// not existing postId
+			cred:         john,
+			postId:       "10000",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			// not the owner of the post
+			cred:         jane,
+			postId:       postID,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			// success
+			cred:         john,
+			postId:       postID,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		c, resp := request("GET", "/v1/posts/:id/revisions", test.cred, []string{"id"}, []string{test.postId})
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleListRevisions)(c)) {
+			assert.Equal(t, test.expectedCode, resp.Code)
+		}
+
+		if test.expectedCode == http.StatusOK {
+			list := model.ListResponse[model.PostRevision]{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+			assert.Equal(t, int64(3), list.Total)
+			require.NotEmpty(t, list.Data)
+			assert.Equal(t, 3, list.Data[0].Revision)
+		}
+	}
+
+	// diff between the first and the latest revision
+	c, resp = request("GET", "/v1/posts/:id/revisions/diff?from=1", john, []string{"id"}, []string{postID})
+	require.NoError(t, srv.AuthenticateUser(srv.handleDiffRevisions)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var diff model.RevisionDiff
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &diff))
+	assert.Equal(t, 3, diff.To)
+	assert.Contains(t, diff.Diff, "--- revision 1")
+	assert.Contains(t, diff.Diff, "+++ revision 3")
+	assert.Contains(t, diff.Diff, "-second line\n")
+	assert.Contains(t, diff.Diff, "+second line changed\n")
+	assert.Contains(t, diff.Diff, "+third line\n")
+
+	// diff to a missing revision
+	c, resp = request("GET", "/v1/posts/:id/revisions/diff?from=1&to=9", john, []string{"id"}, []string{postID})
+	require.NoError(t, srv.AuthenticateUser(srv.handleDiffRevisions)(c))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// only the author can restore
+	c, resp = request("POST", "/v1/posts/:id/revisions/:rev/restore", jane, []string{"id", "rev"}, []string{postID, "1"})
+	require.NoError(t, srv.AuthenticateUser(srv.handleRestoreRevision)(c))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	c, resp = request("POST", "/v1/posts/:id/revisions/:rev/restore", john, []string{"id", "rev"}, []string{postID, "1"})
+	require.NoError(t, srv.AuthenticateUser(srv.handleRestoreRevision)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var restored model.Post
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &restored))
+	assert.Equal(t, "first line\nsecond line", restored.Content)
+	assert.Equal(t, 4, restored.Revision)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+)
+
+// RequirePermission only lets users whose role has the permission through.
+// It goes after AuthenticateUser, which tells it who the user is.
+func (s *Server) RequirePermission(permission model.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, err := s.currentUser(c)
+			if err != nil || !user.Can(permission) {
+				return echo.NewHTTPError(http.StatusForbidden, "You are not allowed to access this resource.")
+			}
+			return next(c)
+		}
+	}
+}
+
+// currentUser loads the authenticated user, once per request.
+func (s *Server) currentUser(c echo.Context) (*model.User, error) {
+	if user, ok := c.Get("user").(*model.User); ok {
+		return user, nil
+	}
+
+	userID, ok := c.Get("userID").(int)
+	if !ok {
+		return nil, errForbidden
+	}
+	user, err := s.userStore.FindUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	c.Set("user", user)
+	return user, nil
+}
+
+// allowModerators lets editors and admins act on posts of other users.
+// Comments stay with their authors, except for deleting them from a post the
+// moderator may edit.
+func (s *Server) allowModerators(c echo.Context, resource Owned) bool {
+	if _, ok := resource.(*model.Post); !ok {
+		return false
+	}
+
+	user, err := s.currentUser(c)
+	if err != nil {
+		return false
+	}
+	return user.Can(model.PermissionEditAnyPost)
+}
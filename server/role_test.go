@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	admin := &model.LoginRequest{Email: "admin@gmail.com", Password: "12345678"}
+	editor := &model.LoginRequest{Email: "editor@gmail.com", Password: "12345678"}
+	author := &model.LoginRequest{Email: "author@gmail.com", Password: "12345678"}
+	for _, cred := range []*model.LoginRequest{admin, editor, author} {
+		createTestUser(t, model.RegisterRequest{Name: "roles", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+	}
+
+	userID := func(cred *model.LoginRequest) int {
+		var u *model.User
+		u, err := srv.authStore.FindUser(u, cred.Email)
+		require.NoError(t, err)
+		return int(u.ID)
+	}
+	require.NoError(t, srv.userStore.SetUserRole(userID(admin), model.RoleAdmin))
+
+	adminRequest := func(method string, handler echo.HandlerFunc, id int, body interface{}, cred *model.LoginRequest) (*httptest.ResponseRecorder, error) {
+		c, resp := makeRequest(method, "/v1/admin/users/:id", body, true, cred)
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(id))
+		err := srv.AuthenticateUser(srv.RequirePermission(model.PermissionManageUsers)(handler))(c)
+		return resp, err
+	}
+
+	tests := []struct {
+		name         string
+		cred         *model.LoginRequest
+		body         interface{}
+		id           int
+		expectedCode int
+		expectedRole string
+	}{
+		{
+			name:         "users can't change roles",
+			cred:         author,
+			body:         model.RoleRequest{Role: model.RoleAdmin},
+			id:           userID(author),
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unknown role",
+			cred:         admin,
+			body:         model.RoleRequest{Role: "owner"},
+			id:           userID(editor),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "admins can't change their own role",
+			cred:         admin,
+			body:         model.RoleRequest{Role: model.RoleUser},
+			id:           userID(admin),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unknown user",
+			cred:         admin,
+			body:         model.RoleRequest{Role: model.RoleEditor},
+			id:           1 << 30,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "admin makes an editor",
+			cred:         admin,
+			body:         model.RoleRequest{Role: model.RoleEditor},
+			id:           userID(editor),
+			expectedCode: http.StatusOK,
+			expectedRole: model.RoleEditor,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := adminRequest("PUT", srv.handleChangeUserRole, tc.id, tc.body, tc.cred)
+			if he, ok := err.(*echo.HTTPError); ok {
+				assert.Equal(t, tc.expectedCode, he.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, resp.Code)
+
+			if tc.expectedRole != "" {
+				var u model.UserResponse
+				require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &u))
+				assert.Equal(t, tc.expectedRole, u.Role)
+			}
+		})
+	}
+
+	t.Run("admin lists users by role", func(t *testing.T) {
+		c, resp := makeRequest("GET", "/v1/admin/users?role=editor", nil, true, admin)
+		require.NoError(t, srv.AuthenticateUser(srv.RequirePermission(model.PermissionManageUsers)(srv.handleListUsers))(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		var list model.ListResponse[model.UserResponse]
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+		if assert.Len(t, list.Data, 1) {
+			assert.Equal(t, editor.Email, list.Data[0].Email)
+		}
+		assert.Equal(t, int64(1), list.Total)
+	})
+
+	t.Run("editor moderates posts of others", func(t *testing.T) {
+		c, resp := makeRequest("POST", "/v1/posts/", &model.CreatePostRequest{Title: "Moderated", Content: "Needs a moderator"}, true, author)
+		require.NoError(t, srv.AuthenticateUser(srv.handleCreatePost)(c))
+		require.Equal(t, http.StatusCreated, resp.Code)
+
+		var post model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &post))
+		id := strconv.Itoa(int(post.ID))
+
+		c, resp = makeRequest("PUT", "/v1/posts/:id", &model.UpdatePostRequest{Title: "Moderated by an editor"}, true, editor)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, srv.AuthenticateUser(srv.handleUpdatePost)(c))
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		// users can't unpublish posts, not even their own
+		c, _ = makeRequest("POST", "/v1/admin/posts/:id/unpublish", nil, true, author)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		err := srv.AuthenticateUser(srv.RequirePermission(model.PermissionUnpublishPost)(srv.handleUnpublishPost))(c)
+		if he, ok := err.(*echo.HTTPError); assert.True(t, ok) {
+			assert.Equal(t, http.StatusForbidden, he.Code)
+		}
+
+		c, resp = makeRequest("POST", "/v1/admin/posts/:id/unpublish", nil, true, editor)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, srv.AuthenticateUser(srv.RequirePermission(model.PermissionUnpublishPost)(srv.handleUnpublishPost))(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		var unpublished model.Post
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &unpublished))
+		assert.Equal(t, model.PostStatusDraft, unpublished.Status)
+		assert.Equal(t, "Moderated by an editor", unpublished.Title)
+	})
+
+	t.Run("suspended users are signed out and can't log in", func(t *testing.T) {
+		c, _ := makeRequest("GET", "/v1/user/me", nil, true, author)
+
+		resp, err := adminRequest("POST", srv.handleSuspendUser, userID(author), nil, admin)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		// the token issued before the suspension is revoked
+		err = srv.AuthenticateUser(srv.handleGetMe)(c)
+		if he, ok := err.(*echo.HTTPError); assert.True(t, ok) {
+			assert.Equal(t, http.StatusUnauthorized, he.Code)
+		}
+
+		c, resp = makeRequest("POST", "/v1/auth/login", author, false, nil)
+		require.NoError(t, srv.handleLogin(c))
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp, err = adminRequest("POST", srv.handleUnsuspendUser, userID(author), nil, admin)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		c, resp = makeRequest("POST", "/v1/auth/login", author, false, nil)
+		require.NoError(t, srv.handleLogin(c))
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("admin deletes a user", func(t *testing.T) {
+		id := userID(author)
+		resp, err := adminRequest("DELETE", srv.handleDeleteUser, id, nil, admin)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		_, err = srv.userStore.FindUser(id)
+		assert.Error(t, err)
+	})
+}
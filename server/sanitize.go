@@ -0,0 +1,204 @@
+package server
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedAttributes lists the elements kept by sanitizeHTML with the
+// attributes they may keep. Other elements are replaced by their children.
+var allowedAttributes = map[atom.Atom][]string{
+	atom.A:          {"href", "title"},
+	atom.Abbr:       {"title"},
+	atom.B:          nil,
+	atom.Blockquote: nil,
+	atom.Br:         nil,
+	atom.Code:       {"class"},
+	atom.Dd:         nil,
+	atom.Del:        nil,
+	atom.Dl:         nil,
+	atom.Dt:         nil,
+	atom.Em:         nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Hr:         nil,
+	atom.I:          nil,
+	atom.Img:        {"src", "alt", "title"},
+	atom.Input:      {"type", "checked"},
+	atom.Kbd:        nil,
+	atom.Li:         nil,
+	atom.Ol:         {"start"},
+	atom.P:          nil,
+	atom.Pre:        nil,
+	atom.S:          nil,
+	atom.Strong:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Table:      nil,
+	atom.Tbody:      nil,
+	atom.Td:         {"align"},
+	atom.Th:         {"align"},
+	atom.Thead:      nil,
+	atom.Tr:         nil,
+	atom.Ul:         nil,
+}
+
+// droppedElements are removed together with their content.
+var droppedElements = map[atom.Atom]bool{
+	atom.Applet:   true,
+	atom.Embed:    true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Iframe:   true,
+	atom.Noembed:  true,
+	atom.Noframes: true,
+	atom.Noscript: true,
+	atom.Object:   true,
+	atom.Script:   true,
+	atom.Select:   true,
+	atom.Style:    true,
+	atom.Template: true,
+	atom.Textarea: true,
+	atom.Title:    true,
+}
+
+var (
+	codeClass    = regexp.MustCompile(`^language-[A-Za-z0-9_+-]+$`)
+	linkSchemes  = map[string]bool{"http": true, "https": true, "mailto": true}
+	imageSchemes = map[string]bool{"http": true, "https": true}
+)
+
+// sanitizeHTML keeps the formatting of an HTML fragment and removes anything
+// that could run code in a browser: scripts, frames, event handler and style
+// attributes, and links to other than web and mail addresses.
+func sanitizeHTML(fragment string) (string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return "", err
+	}
+
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	sanitizeChildren(root)
+
+	var b strings.Builder
+	for n := root.FirstChild; n != nil; n = n.NextSibling {
+		if err := html.Render(&b, n); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func sanitizeChildren(parent *html.Node) {
+	var next *html.Node
+	for n := parent.FirstChild; n != nil; n = next {
+		next = n.NextSibling
+
+		switch n.Type {
+		case html.TextNode:
+			continue
+		case html.ElementNode:
+		default:
+			parent.RemoveChild(n)
+			continue
+		}
+
+		if droppedElements[n.DataAtom] {
+			parent.RemoveChild(n)
+			continue
+		}
+
+		sanitizeChildren(n)
+
+		allowed, ok := allowedAttributes[n.DataAtom]
+		if !ok {
+			for child := n.FirstChild; child != nil; child = n.FirstChild {
+				n.RemoveChild(child)
+				parent.InsertBefore(child, n)
+			}
+			parent.RemoveChild(n)
+			continue
+		}
+
+		n.Attr = sanitizeAttributes(n, allowed)
+		if n.DataAtom == atom.Input && !isCheckbox(n) {
+			parent.RemoveChild(n)
+		}
+	}
+}
+
+func sanitizeAttributes(n *html.Node, allowed []string) []html.Attribute {
+	var attrs []html.Attribute
+	for _, attr := range n.Attr {
+		if attr.Namespace != "" || !contains(allowed, attr.Key) {
+			continue
+		}
+
+		switch attr.Key {
+		case "href":
+			if !safeURL(attr.Val, linkSchemes) {
+				continue
+			}
+		case "src":
+			if !safeURL(attr.Val, imageSchemes) {
+				continue
+			}
+		case "class":
+			if !codeClass.MatchString(attr.Val) {
+				continue
+			}
+		}
+		attrs = append(attrs, attr)
+	}
+
+	switch n.DataAtom {
+	case atom.A:
+		attrs = append(attrs, html.Attribute{Key: "rel", Val: "nofollow noopener ugc"})
+	case atom.Input:
+		// task list checkboxes can only be changed by editing the post
+		attrs = append(attrs, html.Attribute{Key: "disabled", Val: ""})
+	}
+	return attrs
+}
+
+// safeURL reports whether u is relative or uses one of the schemes.
+func safeURL(u string, schemes map[string]bool) bool {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	if parsed.Scheme == "" {
+		return parsed.Opaque == ""
+	}
+	return schemes[parsed.Scheme]
+}
+
+func isCheckbox(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == "type" {
+			return strings.EqualFold(attr.Val, "checkbox")
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			// formatting is kept
+			input:    `<p>Hello <strong>world</strong></p>`,
+			expected: `<p>Hello <strong>world</strong></p>`,
+		},
+		{
+			// scripts are removed with their content
+			input:    `<p>hi</p><script>alert(1)</script>`,
+			expected: `<p>hi</p>`,
+		},
+		{
+			// frames are removed
+			input:    `<iframe src="https://example.com"></iframe><p>after</p>`,
+			expected: `<p>after</p>`,
+		},
+		{
+			// event handlers and styles are removed
+			input:    `<img src="https://example.com/a.png" onerror="alert(1)" style="width:1px">`,
+			expected: `<img src="https://example.com/a.png"/>`,
+		},
+		{
+			// javascript links lose their target
+			input:    `<a href="javascript:alert(1)" onclick="alert(1)">x</a>`,
+			expected: `<a rel="nofollow noopener ugc">x</a>`,
+		},
+		{
+			// entity encoded schemes are caught too
+			input:    `<a href="jav&#x09;ascript:alert(1)">x</a>`,
+			expected: `<a rel="nofollow noopener ugc">x</a>`,
+		},
+		{
+			// unknown elements are replaced by their content
+			input:    `<div><span class="x">text</span></div>`,
+			expected: `text`,
+		},
+		{
+			// text is escaped
+			input:    `<svg><p>1 &lt; 2</p></svg>`,
+			expected: `<p>1 &lt; 2</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		output, err := sanitizeHTML(test.input)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, output, test.input)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartScheduler publishes scheduled posts once their publish time has come,
+// queues media still waiting for their variants, deletes media no post uses
+// and purges deleted accounts and posts, checking every interval until ctx is
+// done.
+func (s *Server) StartScheduler(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.publishDuePosts()
+			s.queuePendingMedia()
+			s.collectMedia(time.Now().Add(-mediaGracePeriod))
+			s.pruneLoginAttempts()
+			s.pruneRateLimits()
+			s.purgeDeleted()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Server) publishDuePosts() {
+	n, err := s.postStore.PublishDuePosts(time.Now())
+	if err != nil {
+		log.Printf("[scheduler] error: publishing scheduled posts: %s", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[scheduler] published %d scheduled posts", n)
+	}
+}
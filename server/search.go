@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+func (s *Server) handleSearchPosts(c echo.Context) error {
+	q := strings.TrimSpace(c.QueryParams().Get("q"))
+	if q == "" {
+		return RespondWithError(c, http.StatusBadRequest, "Provide a search query with q")
+	}
+
+	page, limit := pageParams(c)
+	offset := (page - 1) * limit
+
+	total, err := s.searchStore.CountSearchResults(q)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	results, err := s.searchStore.SearchPosts(q, limit, offset)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	for _, r := range results {
+		s.renderPost(&r.Post)
+	}
+	return RespondWithList(c, pageList(c, results, total, page, limit))
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSearchPosts(t *testing.T) {
+	var u *model.User
+	u, err := srv.authStore.FindUser(u, "johndoe@gmail.com")
+	require.NoError(t, err)
+
+	require.NoError(t, srv.postStore.CreatePost(&model.Post{UserID: u.ID, Title: "Gardening", Content: "Growing <b>tomatoes</b> in containers on a balcony", CreatedAt: time.Now(), UpdatedAt: time.Now()}))
+	require.NoError(t, srv.postStore.CreatePost(&model.Post{UserID: u.ID, Title: "Tomatoes", Content: "Tomato sauce recipes", CreatedAt: time.Now(), UpdatedAt: time.Now()}))
+
+	tests := []struct {
+		method            string
+		route             string
+		authReq           bool
+		cred              *model.LoginRequest
+		expectedError     bool
+		expectedErrorDesc string
+		expectedCode      int
+		expectedTitles    []string
+	}{
+		{
+			// missing auth token
+			method:            "GET",
+			route:             "/v1/posts/search?q=tomato",
+			authReq:           false,
+			cred:              nil,
+			expectedError:     true,
+			expectedErrorDesc: "You must be logged in to access this resource.",
+			expectedCode:      http.StatusUnauthorized,
+		},
+		{
+			// missing query
+			method:            "GET",
+			route:             "/v1/posts/search",
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
+			expectedError:     true,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusBadRequest,
+		},
+		{
+			// title matches rank first
+			method:            "GET",
+			route:             "/v1/posts/search?q=tomato",
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
+			expectedError:     false,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusOK,
+			expectedTitles:    []string{"Tomatoes", "Gardening"},
+		},
+		{
+			// pagination
+			method:            "GET",
+			route:             "/v1/posts/search?q=tomato&page=2&limit=1",
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
+			expectedError:     false,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusOK,
+			expectedTitles:    []string{"Gardening"},
+		},
+		{
+			// no match
+			method:            "GET",
+			route:             "/v1/posts/search?q=potato",
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
+			expectedError:     false,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusOK,
+			expectedTitles:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		c, resp := makeRequest(test.method, test.route, nil, test.authReq, test.cred)
+
+		if test.expectedError && test.expectedErrorDesc != "" {
+			err := srv.AuthenticateUser(srv.handleSearchPosts)(c)
+			assert.NotNil(t, err)
+
+			he, ok := err.(*echo.HTTPError)
+			assert.True(t, ok)
+
+			assert.Equal(t, test.expectedCode, he.Code)
+			assert.Equal(t, test.expectedErrorDesc, he.Message)
+
+			continue
+
+		}
+
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleSearchPosts)(c)) {
+			assert.Equal(t, test.expectedCode, resp.Code)
+		}
+
+		if !test.expectedError {
+			list := model.ListResponse[model.SearchResult]{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+
+			titles := []string{}
+			for _, result := range list.Data {
+				titles = append(titles, result.Title)
+				assert.Contains(t, result.Snippet, "<mark>")
+				assert.NotContains(t, result.Snippet, "<b>")
+			}
+			assert.Equal(t, test.expectedTitles, titles)
+		}
+	}
+}
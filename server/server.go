@@ -1,6 +1,10 @@
 package server
 
 import (
+	"os"
+	"strings"
+	"sync"
+
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/repository"
 )
@@ -8,12 +12,47 @@ import (
 type Server struct {
 	E *echo.Echo
 
-	authStore repository.AuthStore
-	postStore repository.PostStore
-	userStore repository.UserStore
+	authStore     repository.AuthStore
+	postStore     repository.PostStore
+	userStore     repository.UserStore
+	sessionStore  repository.SessionStore
+	commentStore  repository.CommentStore
+	tagStore      repository.TagStore
+	searchStore   repository.SearchStore
+	revisionStore repository.RevisionStore
+	mediaStore    repository.MediaStore
+	blobStore     repository.BlobStore
+	mailer        repository.Mailer
+	loginAttempts repository.LoginAttemptStore
+	// rateLimitStore keeps the buckets of the rateLimits policies
+	rateLimitStore repository.RateLimitStore
+	rateLimits     map[string]repository.RateLimit
+	// keys sign and verify access tokens and the tokens of emailed links
+	keys *KeySet
+	// apiKeyScopes maps the routes API keys may use to the scope they need
+	apiKeyScopes map[string]string
+
+	bypasses []OwnershipBypass
+	cookies  cookieConfig
+	renderer *contentRenderer
+	// baseURL is where the API is reachable, for links in emails
+	baseURL string
+	// trustProxy believes the client address a proxy forwards
+	trustProxy bool
+
+	mediaQueue *mediaQueue
+	// mailing tracks the emails sent after responding, which tests wait for
+	mailing sync.WaitGroup
+
+	oidcProviders map[string]*oidcProvider
 }
 
-func NewServer(authStore repository.AuthStore, postStore repository.PostStore, userStore repository.UserStore) *Server {
-	return &Server{E: echo.New(),
-		authStore: authStore, postStore: postStore, userStore: userStore}
+func NewServer(authStore repository.AuthStore, postStore repository.PostStore, userStore repository.UserStore, sessionStore repository.SessionStore, commentStore repository.CommentStore, tagStore repository.TagStore, searchStore repository.SearchStore, revisionStore repository.RevisionStore, mediaStore repository.MediaStore, blobStore repository.BlobStore, mailer repository.Mailer, loginAttempts repository.LoginAttemptStore, rateLimitStore repository.RateLimitStore, keys *KeySet) *Server {
+	s := &Server{E: echo.New(),
+		authStore: authStore, postStore: postStore, userStore: userStore, sessionStore: sessionStore, commentStore: commentStore, tagStore: tagStore, searchStore: searchStore, revisionStore: revisionStore, mediaStore: mediaStore, blobStore: blobStore, mailer: mailer, loginAttempts: loginAttempts, rateLimitStore: rateLimitStore, keys: keys, rateLimits: defaultRateLimits(), apiKeyScopes: make(map[string]string),
+		cookies: cookieConfigFromEnv(), baseURL: strings.TrimSuffix(os.Getenv("APP_URL"), "/"), trustProxy: os.Getenv("TRUST_PROXY") == "true", renderer: newContentRenderer(), mediaQueue: newMediaQueue(mediaQueueSize),
+		oidcProviders: make(map[string]*oidcProvider)}
+	s.AllowOwnershipBypass(s.allowModerators)
+	s.E.Use(s.RateLimit)
+	return s
 }
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+)
+
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// randomToken returns n random bytes encoded for use in URLs and cookies.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is used to store refresh tokens and other secrets that only need
+// to be looked up, never read back.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshSession creates a refresh token for the given session family and
+// returns it together with the row to store.
+func newRefreshSession(userID uint, familyID string) (string, *model.Session, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &model.Session{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// newSession starts a new session family for the user and returns its access
+// and refresh tokens.
+func (s *Server) newSession(userID uint) (string, string, error) {
+	familyID, err := randomToken(24)
+	if err != nil {
+		return "", "", err
+	}
+
+	refresh, session, err := newRefreshSession(userID, familyID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.sessionStore.CreateSession(session); err != nil {
+		return "", "", err
+	}
+
+	access, err := s.keys.CreateToken(userID, familyID, accessTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	return access, refresh, nil
+}
+
+func tokenResponse(access, refresh string) model.TokenResponse {
+	return model.TokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}
+}
+
+// cookieConfig holds the attributes shared by every cookie the server sets.
+type cookieConfig struct {
+	secure   bool
+	sameSite http.SameSite
+}
+
+// cookieConfigFromEnv reads COOKIE_SECURE and COOKIE_SAMESITE (strict, lax or
+// none). SameSite=None is only accepted by browsers on secure cookies.
+func cookieConfigFromEnv() cookieConfig {
+	cfg := cookieConfig{
+		secure:   os.Getenv("COOKIE_SECURE") == "true",
+		sameSite: http.SameSiteLaxMode,
+	}
+
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "strict":
+		cfg.sameSite = http.SameSiteStrictMode
+	case "none":
+		cfg.sameSite = http.SameSiteNoneMode
+		cfg.secure = true
+	}
+
+	return cfg
+}
+
+func (s *Server) newCookie(name, value, path string, expires time.Time, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  expires.UTC(),
+		HttpOnly: httpOnly,
+		Secure:   s.cookies.secure,
+		SameSite: s.cookies.sameSite,
+		Path:     path,
+	}
+}
+
+// setSessionCookies stores the tokens in cookies along with a new CSRF token,
+// which is readable by scripts so it can be sent back in the X-CSRF-Token
+// header.
+func (s *Server) setSessionCookies(c echo.Context, access, refresh string) error {
+	csrf, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(s.newCookie("access-token", access, "/", time.Now().Add(accessTokenTTL), true))
+	c.SetCookie(s.newCookie("refresh-token", refresh, "/v1/auth", time.Now().Add(refreshTokenTTL), true))
+	c.SetCookie(s.newCookie("csrf-token", csrf, "/", time.Now().Add(refreshTokenTTL), false))
+	return nil
+}
+
+func (s *Server) clearSessionCookies(c echo.Context) {
+	expired := time.Now().Add(-time.Hour)
+	c.SetCookie(s.newCookie("access-token", "", "/", expired, true))
+	c.SetCookie(s.newCookie("refresh-token", "", "/v1/auth", expired, true))
+	c.SetCookie(s.newCookie("csrf-token", "", "/", expired, false))
+}
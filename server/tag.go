@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+)
+
+func (s *Server) RegisterTagRoutes(g *echo.Group) {
+	router := g.Group("/tags")
+	router.Use(s.AuthenticateUser)
+	s.allowAPIKey(router.GET("", s.handleListTags), model.ScopePostsRead)
+}
+
+func (s *Server) handleListTags(c echo.Context) error {
+	page, limit := pageParams(c)
+
+	total, err := s.tagStore.CountTags()
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	tags, err := s.tagStore.FindTags(limit, (page-1)*limit)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	return RespondWithList(c, pageList(c, tags, total, page, limit))
+}
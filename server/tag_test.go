@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleListTags(t *testing.T) {
+
+	tests := []struct {
+		method            string
+		route             string
+		authReq           bool
+		cred              *model.LoginRequest
+		expectedError     bool
+		expectedErrorDesc string
+		expectedCode      int
+	}{
+		{
+			// missing auth token
+			method:            "GET",
+			route:             "/v1/tags",
+			authReq:           false,
+			cred:              nil,
+			expectedError:     true,
+			expectedErrorDesc: "You must be logged in to access this resource.",
+			expectedCode:      http.StatusUnauthorized,
+		},
+		{
+			// success
+			method:            "GET",
+			route:             "/v1/tags",
+			authReq:           true,
+			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
+			expectedError:     false,
+			expectedErrorDesc: "",
+			expectedCode:      http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		c, resp := makeRequest(test.method, test.route, nil, test.authReq, test.cred)
+
+		if test.expectedError && test.expectedErrorDesc != "" {
+			err := srv.AuthenticateUser(srv.handleListTags)(c)
+			assert.NotNil(t, err)
+
+			he, ok := err.(*echo.HTTPError)
+			assert.True(t, ok)
+
+			assert.Equal(t, test.expectedCode, he.Code)
+			assert.Equal(t, test.expectedErrorDesc, he.Message)
+
+			continue
+
+		}
+
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleListTags)(c)) {
+			assert.Equal(t, test.expectedCode, resp.Code)
+		}
+
+		if !test.expectedError {
+			list := model.ListResponse[model.TagCount]{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &list))
+			assert.Equal(t, int64(2), list.Total)
+			counts := map[string]int64{}
+			for _, tag := range list.Data {
+				counts[tag.Name] = tag.Count
+			}
+			assert.Equal(t, int64(1), counts["go"])
+			assert.Equal(t, int64(1), counts["news"])
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+)
+
+// Codes follow RFC 6238 with the defaults authenticator apps expect: SHA-1,
+// 6 digits and a new code every 30 seconds. Codes of the step before and
+// after the current one are accepted too, for clocks that are a bit off.
+const (
+	totpIssuer = "Blog API"
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1
+
+	recoveryCodeCount = 10
+
+	loginChallengeTTL = 5 * time.Minute
+	// maxLoginChallengeAttempts is how many codes can be tried per
+	// challenge, after which the password has to be entered again
+	maxLoginChallengeAttempts = 5
+)
+
+var errInvalidCode = errors.New("The code is invalid")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160 bit secret, base32 encoded as
+// authenticator apps expect.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode computes the code of a time step, as defined by RFC 4226.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP checks a code against the secret and returns the time step it
+// belongs to.
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpURI(secret, email string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(totpIssuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// newRecoveryCodes returns recovery codes to show the user once, with the
+// hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes in recovery codes
+// typed in by users.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor checks a code from the authenticator app of the user, or
+// one of their recovery codes, using it up.
+func (s *Server) checkSecondFactor(user *model.User, code string) error {
+	code = strings.TrimSpace(code)
+	if step, ok := verifyTOTP(user.TOTPSecret, code, time.Now()); ok {
+		fresh, err := s.authStore.UseTOTPStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errInvalidCode
+		}
+		return nil
+	}
+
+	if len(code) == totpDigits {
+		return errInvalidCode
+	}
+	used, err := s.authStore.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errInvalidCode
+	}
+	return nil
+}
+
+// startLoginChallenge answers a login with a correct password with a
+// challenge token, to be sent along with a code to handleTwoFactorLogin.
+func (s *Server) startLoginChallenge(c echo.Context, user *model.User, returnToken bool) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	challenge := &model.LoginChallenge{
+		UserID:      user.ID,
+		TokenHash:   hashToken(token),
+		ReturnToken: returnToken,
+		ExpiresAt:   time.Now().Add(loginChallengeTTL),
+		CreatedAt:   time.Now(),
+	}
+	if err := s.authStore.CreateLoginChallenge(challenge); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, model.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresAt:         challenge.ExpiresAt,
+	})
+}
+
+// handleTwoFactorLogin completes a login with a code from the authenticator
+// app or a recovery code.
+func (s *Server) handleTwoFactorLogin(c echo.Context) error {
+	r := new(model.TwoFactorLoginRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	challenge, err := s.authStore.AttemptLoginChallenge(hashToken(r.ChallengeToken), maxLoginChallengeAttempts)
+	if errors.Is(err, repository.ErrInvalidLoginChallenge) {
+		return RespondWithError(c, http.StatusUnauthorized, "The login has expired, log in again")
+	}
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	user, err := s.findLoginUser(int(challenge.UserID))
+	if err != nil {
+		return RespondWithError(c, http.StatusUnauthorized, "The login has expired, log in again")
+	}
+	if user.IsSuspended() {
+		return RespondWithError(c, http.StatusForbidden, "Your account has been suspended")
+	}
+
+	// wrong codes count like wrong passwords, or a known password would
+	// allow guessing codes with a new challenge every few attempts
+	ipKey, accountKey := s.loginKeys(c, user.Email)
+	wait, err := s.loginLockout(ipKey, accountKey)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if wait > 0 {
+		return respondWithLockout(c, wait)
+	}
+
+	if err := s.checkSecondFactor(user, r.Code); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			if err := s.failLogin(ipKey, accountKey); err != nil {
+				return RespondWithError(c, http.StatusInternalServerError, err.Error())
+			}
+		}
+		return respondWithCodeError(c, err)
+	}
+
+	if err := s.authStore.UseLoginChallenge(challenge.ID); err != nil {
+		return RespondWithError(c, http.StatusUnauthorized, "The login has expired, log in again")
+	}
+
+	return s.startSession(c, user, challenge.ReturnToken)
+}
+
+// handleSetupTwoFactor starts turning two-factor authentication on, returning
+// the secret to add to an authenticator app. It's on once confirmed with a
+// first code.
+func (s *Server) handleSetupTwoFactor(c echo.Context) error {
+	r := new(model.TwoFactorSetupRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	user, err := s.reauthenticate(c, r.CurrentPassword)
+	if err != nil {
+		return respondWithReauthError(c, err)
+	}
+	if user.HasTwoFactor() {
+		return RespondWithError(c, http.StatusConflict, "Two-factor authentication is already on")
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if err := s.authStore.SetTOTPSecret(user.ID, secret); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, model.TwoFactorSetupResponse{
+		Secret: secret,
+		URI:    totpURI(secret, user.Email),
+	})
+}
+
+// handleConfirmTwoFactor turns two-factor authentication on with a first code
+// from the authenticator app, returning the recovery codes.
+func (s *Server) handleConfirmTwoFactor(c echo.Context) error {
+	r := new(model.TwoFactorCodeRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	user, err := s.currentUser(c)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if user.HasTwoFactor() {
+		return RespondWithError(c, http.StatusConflict, "Two-factor authentication is already on")
+	}
+	if user.TOTPSecret == "" {
+		return RespondWithError(c, http.StatusBadRequest, "Set up two-factor authentication first")
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, strings.TrimSpace(r.Code), time.Now())
+	if !ok {
+		return RespondWithError(c, http.StatusBadRequest, errInvalidCode.Error())
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if err := s.authStore.EnableTOTP(user.ID, step, hashes); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handleDisableTwoFactor turns two-factor authentication off, given the
+// current password and a code.
+func (s *Server) handleDisableTwoFactor(c echo.Context) error {
+	r := new(model.TwoFactorCodeRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	user, err := s.reauthenticate(c, r.CurrentPassword)
+	if err != nil {
+		return respondWithReauthError(c, err)
+	}
+	if !user.HasTwoFactor() {
+		return RespondWithError(c, http.StatusBadRequest, "Two-factor authentication is off")
+	}
+
+	if err := s.checkSecondFactor(user, r.Code); err != nil {
+		return respondWithCodeError(c, err)
+	}
+
+	if err := s.authStore.DisableTOTP(user.ID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusNoContent, nil)
+}
+
+func respondWithCodeError(c echo.Context, err error) error {
+	if errors.Is(err, errInvalidCode) {
+		return RespondWithError(c, http.StatusUnauthorized, err.Error())
+	}
+	return RespondWithError(c, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{time: 59, code: "287082"},
+		{time: 1111111109, code: "081804"},
+		{time: 1234567890, code: "005924"},
+		{time: 2000000000, code: "279037"},
+		{time: 20000000000, code: "353130"},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.code, totpCode(key, tc.time/totpPeriod))
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	now := time.Unix(59, 0)
+	_, ok := verifyTOTP(secret, "287082", now)
+	assert.True(t, ok)
+	_, ok = verifyTOTP(secret, "287082", now.Add(2*totpPeriod*time.Second))
+	assert.False(t, ok, "codes expire")
+}
+
+func TestTwoFactor(t *testing.T) {
+	cred := &model.LoginRequest{Email: "careful@gmail.com", Password: "12345678"}
+	createTestUser(t, model.RegisterRequest{Name: "careful", Email: cred.Email, Password: cred.Password, PasswordConfirm: cred.Password})
+
+	c, resp := makeRequest("POST", "/v1/user/2fa/setup", model.TwoFactorSetupRequest{CurrentPassword: "87654321"}, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleSetupTwoFactor)(c))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	c, resp = makeRequest("POST", "/v1/user/2fa/setup", model.TwoFactorSetupRequest{CurrentPassword: cred.Password}, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleSetupTwoFactor)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var setup model.TwoFactorSetupResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &setup))
+	assert.True(t, strings.HasPrefix(setup.URI, "otpauth://totp/Blog%20API:careful@gmail.com?"))
+	assert.Contains(t, setup.URI, "secret="+setup.Secret)
+
+	key, err := totpEncoding.DecodeString(setup.Secret)
+	require.NoError(t, err)
+	step := time.Now().Unix() / totpPeriod
+
+	c, resp = makeRequest("POST", "/v1/user/2fa/confirm", model.TwoFactorCodeRequest{Code: "000000"}, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleConfirmTwoFactor)(c))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	c, resp = makeRequest("POST", "/v1/user/2fa/confirm", model.TwoFactorCodeRequest{Code: totpCode(key, step)}, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleConfirmTwoFactor)(c))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var recovery model.RecoveryCodesResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &recovery))
+	require.Len(t, recovery.RecoveryCodes, recoveryCodeCount)
+
+	login := func() string {
+		c, resp := makeRequest("POST", "/v1/auth/login", cred, false, nil)
+		require.NoError(t, srv.handleLogin(c))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Empty(t, resp.Header().Values("Set-Cookie"), "no session before the second factor")
+
+		var challenge model.TwoFactorChallengeResponse
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &challenge))
+		require.True(t, challenge.TwoFactorRequired)
+		return challenge.ChallengeToken
+	}
+	// twoFactorLogin sends the code from an address of its own, as wrong
+	// codes are counted against it
+	twoFactorLogin := func(token, code string) int {
+		c, resp := makeRequest("POST", "/v1/auth/2fa", model.TwoFactorLoginRequest{ChallengeToken: token, Code: code}, false, nil)
+		c.Request().RemoteAddr = "198.51.100.70:1234"
+		require.NoError(t, srv.handleTwoFactorLogin(c))
+		return resp.Code
+	}
+	challenge := login()
+
+	tests := []struct {
+		name         string
+		token        string
+		code         string
+		expectedCode int
+	}{
+		{
+			name:         "unknown challenge",
+			token:        "x" + challenge,
+			code:         totpCode(key, step+1),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "wrong code",
+			token:        challenge,
+			code:         "000000",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "codes work once",
+			token:        challenge,
+			code:         totpCode(key, step),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "code",
+			token:        challenge,
+			code:         totpCode(key, step+1),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "challenges work once",
+			token:        challenge,
+			code:         recovery.RecoveryCodes[0],
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedCode, twoFactorLogin(tc.token, tc.code))
+		})
+	}
+
+	t.Run("recovery codes work once", func(t *testing.T) {
+		for _, expectedCode := range []int{http.StatusOK, http.StatusUnauthorized} {
+			assert.Equal(t, expectedCode, twoFactorLogin(login(), strings.ToUpper(recovery.RecoveryCodes[1])))
+		}
+	})
+
+	t.Run("challenges allow a few attempts", func(t *testing.T) {
+		challenge := login()
+		for i := 0; i < maxLoginChallengeAttempts; i++ {
+			twoFactorLogin(challenge, "000000")
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, twoFactorLogin(challenge, recovery.RecoveryCodes[2]))
+	})
+
+	t.Run("wrong codes count toward the lockout", func(t *testing.T) {
+		// a used recovery code and the attempts above are one more than
+		// accountBackoff allows
+		c, resp := makeRequest("POST", "/v1/auth/login", cred, false, nil)
+		require.NoError(t, srv.handleLogin(c))
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+
+		require.NoError(t, srv.loginAttempts.ResetLogin("email:"+hashToken(cred.Email)))
+	})
+
+	c, resp = makeRequest("DELETE", "/v1/user/2fa", model.TwoFactorCodeRequest{Code: recovery.RecoveryCodes[3], CurrentPassword: cred.Password}, true, cred)
+	require.NoError(t, srv.AuthenticateUser(srv.handleDisableTwoFactor)(c))
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	c, resp = makeRequest("POST", "/v1/auth/login", cred, false, nil)
+	require.NoError(t, srv.handleLogin(c))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NotEmpty(t, resp.Header().Values("Set-Cookie"))
+}
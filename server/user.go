@@ -1,17 +1,33 @@
 package server
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo"
 	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func (s *Server) RegisterUserRoutes(g *echo.Group) {
 	router := g.Group("/user")
-	router.Use(AuthenticateUser)
-	router.GET("/me", s.handleGetMe)
-	router.PATCH("/", s.handleUpdateProfile)
+	router.Use(s.AuthenticateUser)
+	s.allowAPIKey(router.GET("/me", s.handleGetMe), model.ScopeProfile)
+	s.allowAPIKey(router.PATCH("/", s.handleUpdateProfile), model.ScopeProfile)
+	router.PUT("/password", s.handleChangePassword)
+	router.PUT("/email", s.handleChangeEmail)
+	router.POST("/2fa/setup", s.handleSetupTwoFactor)
+	router.POST("/2fa/confirm", s.handleConfirmTwoFactor)
+	router.DELETE("/2fa", s.handleDisableTwoFactor)
+	router.GET("/api-keys", s.handleListAPIKeys)
+	router.POST("/api-keys", s.handleCreateAPIKey)
+	router.DELETE("/api-keys/:id", s.handleRevokeAPIKey)
 	router.DELETE("/", s.handleDeleteProfile)
 }
 
@@ -27,10 +43,12 @@ func (s *Server) handleGetMe(c echo.Context) error {
 	}
 
 	u := model.UserResponse{
-		ID:        user.ID,
-		Name:      user.Name,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
+		ID:         user.ID,
+		Name:       user.Name,
+		Email:      user.Email,
+		CreatedAt:  user.CreatedAt,
+		Role:       user.Role,
+		VerifiedAt: user.VerifiedAt,
 	}
 
 	return RespondWithJSON(c, http.StatusOK, u)
@@ -51,7 +69,7 @@ func (s *Server) handleUpdateProfile(c echo.Context) error {
 		return RespondWithError(c, http.StatusBadRequest, err.Error())
 	}
 
-	user, err := s.userStore.UpdateUser(userID, &model.User{Name: r.Name, Email: r.Email})
+	user, err := s.userStore.UpdateUser(userID, &model.User{Name: r.Name})
 	if err != nil {
 		return RespondWithError(c, http.StatusInternalServerError, err.Error())
 	}
@@ -65,6 +83,8 @@ func (s *Server) handleUpdateProfile(c echo.Context) error {
 	return RespondWithJSON(c, http.StatusOK, u)
 }
 
+// handleDeleteProfile deletes the account of the user, who can restore it by
+// logging in within the restore window. Every session is signed out.
 func (s *Server) handleDeleteProfile(c echo.Context) error {
 	e := new(model.User)
 	if err := c.Bind(e); err != nil {
@@ -76,9 +96,171 @@ func (s *Server) handleDeleteProfile(c echo.Context) error {
 	}
 	e.ID = uint(userID)
 
-	if err := s.userStore.DeleteUser(e); err != nil {
+	if err := s.userStore.DeleteUser(e, false); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := s.sessionStore.RevokeUserSessions(e.ID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	s.clearSessionCookies(c)
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}
+
+// reauthenticate checks the current password of the logged in user before
+// changes that would let a stolen session take over the account.
+func (s *Server) reauthenticate(c echo.Context, password string) (*model.User, error) {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, errWrongPassword
+	}
+	return user, nil
+}
+
+var errWrongPassword = errors.New("The current password is incorrect")
+
+func respondWithReauthError(c echo.Context, err error) error {
+	if errors.Is(err, errWrongPassword) {
+		return RespondWithError(c, http.StatusForbidden, err.Error())
+	}
+	return RespondWithError(c, http.StatusInternalServerError, err.Error())
+}
+
+// handleChangePassword sets a new password, signs the user out of their other
+// sessions and revokes their API keys.
+func (s *Server) handleChangePassword(c echo.Context) error {
+	r := new(model.ChangePasswordRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	user, err := s.reauthenticate(c, r.CurrentPassword)
+	if err != nil {
+		return respondWithReauthError(c, err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(r.Password), 10)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.userStore.SetUserPassword(int(user.ID), string(hash)); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	sessionID, _ := c.Get("sessionID").(string)
+	if err := s.sessionStore.RevokeOtherSessions(user.ID, sessionID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := s.authStore.DeleteUserAPIKeys(user.ID); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}
+
+// handleChangeEmail emails a confirmation link to the new address. The email
+// address only changes once the link is opened.
+func (s *Server) handleChangeEmail(c echo.Context) error {
+	r := new(model.ChangeEmailRequest)
+	if err := c.Bind(r); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := r.Validate(); err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	user, err := s.reauthenticate(c, r.CurrentPassword)
+	if err != nil {
+		return respondWithReauthError(c, err)
+	}
+
+	if r.Email == user.Email {
+		return RespondWithError(c, http.StatusBadRequest, "This is already your email address")
+	}
+	taken, err := s.authStore.EmailTaken(r.Email)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if taken {
+		return RespondWithError(c, http.StatusConflict, "The email address is already in use")
+	}
+
+	token, err := s.createEmailToken(user, purposeChangeEmail, jwt.MapClaims{"new_email": r.Email}, verificationTokenTTL)
+	if err != nil {
 		return RespondWithError(c, http.StatusInternalServerError, err.Error())
 	}
 
-	return c.Redirect(http.StatusSeeOther, "/v1/auth/logout")
+	link := s.appURL("/v1/auth/confirm-email", url.Values{"token": {token}})
+	if err := s.mailer.Send(&repository.Message{
+		To:      r.Email,
+		Subject: "Confirm your new email address",
+		Body: "Hi " + user.Name + ",\n\n" +
+			"Open this link to use this email address for your account:\n\n" +
+			link + "\n\n" +
+			"The link expires in 24 hours. If you didn't ask for it, you can ignore this email.\n",
+	}); err != nil {
+		log.Printf("failed to send email change confirmation to user %d: %s", user.ID, err)
+		return RespondWithError(c, http.StatusInternalServerError, "The confirmation email couldn't be sent")
+	}
+
+	return RespondWithJSON(c, http.StatusAccepted, "A confirmation link has been sent to the new email address")
+}
+
+// handleConfirmEmail changes the email address of a user to the one a
+// confirmation link was sent to, and lets the old address know.
+func (s *Server) handleConfirmEmail(c echo.Context) error {
+	user, claims, err := s.validateEmailToken(c.QueryParam("token"), purposeChangeEmail)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+	email, ok := claims["new_email"].(string)
+	if !ok {
+		return RespondWithError(c, http.StatusBadRequest, errInvalidLink.Error())
+	}
+
+	taken, err := s.authStore.EmailTaken(email)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if taken {
+		return RespondWithError(c, http.StatusConflict, "The email address is already in use")
+	}
+
+	// opening the link proves the address belongs to the user
+	updated := &model.User{Email: email}
+	if !user.IsVerified() {
+		now := time.Now()
+		updated.VerifiedAt = &now
+	}
+	if _, err := s.userStore.UpdateUser(int(user.ID), updated); err != nil {
+		// another account may have taken the address since the check
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return RespondWithError(c, http.StatusConflict, "The email address is already in use")
+		}
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if err := s.mailer.Send(&repository.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body: "Hi " + user.Name + ",\n\n" +
+			"The email address of your account was changed to " + email + ".\n" +
+			"If you didn't do this, contact us right away.\n",
+	}); err != nil {
+		log.Printf("failed to send email change notice to user %d: %s", user.ID, err)
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/orhanfatih/blog-api/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 func TestHandleGetMe(t *testing.T) {
@@ -49,7 +50,7 @@ func TestHandleGetMe(t *testing.T) {
 		c, resp := makeRequest(test.method, test.route, test.body, test.authReq, test.cred)
 
 		if test.expectedError {
-			err := AuthenticateUser(srv.handleGetMe)(c)
+			err := srv.AuthenticateUser(srv.handleGetMe)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -62,7 +63,7 @@ func TestHandleGetMe(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleGetMe)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleGetMe)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 	}
@@ -107,7 +108,7 @@ func TestHandleUpdateProfile(t *testing.T) {
 			// request invalid body field
 			method:            "PATCH",
 			route:             "/v1/user/",
-			body:              &model.ProfileUpdateRequest{Name: "murat", Email: "murat"},
+			body:              &model.ProfileUpdateRequest{Name: "murat the magnificent"},
 			authReq:           true,
 			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
 			expectedError:     true,
@@ -118,7 +119,7 @@ func TestHandleUpdateProfile(t *testing.T) {
 			// successful
 			method:            "PATCH",
 			route:             "/v1/user/",
-			body:              &model.ProfileUpdateRequest{Name: "murat", Email: "murat@gmail.com"},
+			body:              &model.ProfileUpdateRequest{Name: "murat"},
 			authReq:           true,
 			cred:              &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"},
 			expectedError:     false,
@@ -131,7 +132,7 @@ func TestHandleUpdateProfile(t *testing.T) {
 		c, resp := makeRequest(test.method, test.route, test.body, test.authReq, test.cred)
 
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleUpdateProfile)(c)
+			err := srv.AuthenticateUser(srv.handleUpdateProfile)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -144,12 +145,74 @@ func TestHandleUpdateProfile(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleUpdateProfile)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleUpdateProfile)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 	}
 }
 
+func TestChangeEmail(t *testing.T) {
+	john := &model.LoginRequest{Email: "johndoe@gmail.com", Password: "12345678"}
+
+	tests := []struct {
+		name         string
+		body         model.ChangeEmailRequest
+		expectedCode int
+	}{
+		{
+			name:         "invalid email",
+			body:         model.ChangeEmailRequest{Email: "murat", CurrentPassword: john.Password},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "wrong password",
+			body:         model.ChangeEmailRequest{Email: "murat@gmail.com", CurrentPassword: "87654321"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "email in use",
+			body:         model.ChangeEmailRequest{Email: "janedoe@gmail.com", CurrentPassword: john.Password},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "confirmation sent",
+			body:         model.ChangeEmailRequest{Email: "murat@gmail.com", CurrentPassword: john.Password},
+			expectedCode: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, resp := makeRequest("PUT", "/v1/user/email", tc.body, true, john)
+			require.NoError(t, srv.AuthenticateUser(srv.handleChangeEmail)(c))
+			assert.Equal(t, tc.expectedCode, resp.Code)
+		})
+	}
+
+	// nothing changes until the new address is confirmed
+	var u *model.User
+	_, err := srv.authStore.FindUser(u, john.Email)
+	require.NoError(t, err)
+
+	token := lastMailToken(t, "murat@gmail.com", "confirm-email")
+	for _, expectedCode := range []int{http.StatusOK, http.StatusBadRequest} {
+		c, resp := makeRequest("GET", "/v1/auth/confirm-email?token="+token, nil, false, nil)
+		require.NoError(t, srv.handleConfirmEmail(c))
+		assert.Equal(t, expectedCode, resp.Code)
+	}
+
+	_, err = srv.authStore.FindUser(u, "murat@gmail.com")
+	assert.NoError(t, err)
+	assert.Contains(t, mailLog.String(), "The email address of your account was changed to murat@gmail.com")
+
+	// confirmations racing for an address fail on the unique index, which
+	// handleConfirmEmail answers with 409 too
+	jane, err := srv.authStore.FindUser(u, "janedoe@gmail.com")
+	require.NoError(t, err)
+	_, err = srv.userStore.UpdateUser(int(jane.ID), &model.User{Email: "murat@gmail.com"})
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+}
+
 func TestHandleDeleteProfile(t *testing.T) {
 	tests := []struct {
 		method            string
@@ -181,7 +244,7 @@ func TestHandleDeleteProfile(t *testing.T) {
 			cred:              &model.LoginRequest{Email: "murat@gmail.com", Password: "12345678"},
 			expectedError:     false,
 			expectedErrorDesc: "",
-			expectedCode:      http.StatusSeeOther,
+			expectedCode:      http.StatusOK,
 		},
 	}
 
@@ -189,7 +252,7 @@ func TestHandleDeleteProfile(t *testing.T) {
 		c, resp := makeRequest(test.method, test.route, test.body, test.authReq, test.cred)
 
 		if test.expectedError && test.expectedErrorDesc != "" {
-			err := AuthenticateUser(srv.handleDeleteProfile)(c)
+			err := srv.AuthenticateUser(srv.handleDeleteProfile)(c)
 			assert.NotNil(t, err)
 
 			he, ok := err.(*echo.HTTPError)
@@ -202,20 +265,16 @@ func TestHandleDeleteProfile(t *testing.T) {
 
 		}
 
-		if assert.NoError(t, AuthenticateUser(srv.handleDeleteProfile)(c)) {
+		if assert.NoError(t, srv.AuthenticateUser(srv.handleDeleteProfile)(c)) {
 			assert.Equal(t, test.expectedCode, resp.Code)
 		}
 	}
 
 	// recreate user to not affect other tests
-	c, resp := makeRequest("POST", "/v1/auth/register", model.RegisterRequest{
+	createTestUser(t, model.RegisterRequest{
 		Name:            "john",
 		Email:           "johndoe@gmail.com",
 		Password:        "12345678",
 		PasswordConfirm: "12345678",
-	}, false, nil)
-
-	if assert.NoError(t, srv.handleRegister(c)) {
-		require.Equal(t, http.StatusCreated, resp.Code)
-	}
+	})
 }
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/orhanfatih/blog-api/model"
+	"golang.org/x/image/draw"
+)
+
+// mediaQueueSize bounds the uploads waiting for a worker. Uploads that don't
+// fit stay pending until the scheduler queues them again.
+const mediaQueueSize = 256
+
+// mediaVariantSizes are the variants generated for every image, with the
+// size of the box they are scaled down to fit in. The original keeps its size.
+var mediaVariantSizes = []struct {
+	name string
+	size int
+}{
+	{model.MediaVariantThumbnail, 320},
+	{model.MediaVariantMedium, 1024},
+	{model.MediaVariantOriginal, 0},
+}
+
+// mediaQueue holds the IDs of media waiting for their variants, each at most
+// once.
+type mediaQueue struct {
+	jobs chan uint
+
+	mu     sync.Mutex
+	queued map[uint]bool
+}
+
+func newMediaQueue(size int) *mediaQueue {
+	return &mediaQueue{jobs: make(chan uint, size), queued: make(map[uint]bool)}
+}
+
+// push queues the media unless it's already queued or the queue is full.
+func (q *mediaQueue) push(mediaID uint) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[mediaID] {
+		return true
+	}
+
+	select {
+	case q.jobs <- mediaID:
+		q.queued[mediaID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *mediaQueue) done(mediaID uint) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.queued, mediaID)
+}
+
+// StartMediaWorkers starts the given number of workers generating the
+// variants of uploaded images, until ctx is done.
+func (s *Server) StartMediaWorkers(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case mediaID := <-s.mediaQueue.jobs:
+					if err := s.processMedia(mediaID); err != nil {
+						log.Printf("[media] error: processing media %d: %s", mediaID, err)
+					}
+					s.mediaQueue.done(mediaID)
+				}
+			}
+		}()
+	}
+}
+
+// queuePendingMedia queues the media left pending by a full queue or a
+// restart.
+func (s *Server) queuePendingMedia() {
+	media, err := s.mediaStore.FindPendingMedia(mediaQueueSize)
+	if err != nil {
+		log.Printf("[scheduler] error: finding pending media: %s", err)
+		return
+	}
+
+	for _, m := range media {
+		if !s.mediaQueue.push(m.ID) {
+			return
+		}
+	}
+}
+
+// processMedia generates the variants of an upload and deletes the upload,
+// which may contain metadata such as the location a photo was taken at.
+// Uploads that can't be decoded are marked failed, other errors leave the
+// media pending to be tried again.
+func (s *Server) processMedia(mediaID uint) error {
+	media, err := s.mediaStore.FindMedia(int(mediaID))
+	if err != nil {
+		return err
+	}
+	if media.Status != model.MediaStatusPending {
+		return nil
+	}
+
+	img, err := s.decodeUpload(media)
+	if errors.Is(err, errUndecodableMedia) {
+		if statusErr := s.mediaStore.SetMediaStatus(media.ID, model.MediaStatusFailed); statusErr != nil {
+			return statusErr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	variants := make([]*model.MediaVariant, 0, len(mediaVariantSizes))
+	for _, size := range mediaVariantSizes {
+		scaled := fit(img, size.size)
+		data, contentType, err := encodeVariant(scaled)
+		if err != nil {
+			return err
+		}
+
+		variant := &model.MediaVariant{
+			Name:        size.name,
+			Key:         media.Key + "-" + size.name,
+			ContentType: contentType,
+			Size:        int64(len(data)),
+			Width:       scaled.Bounds().Dx(),
+			Height:      scaled.Bounds().Dy(),
+		}
+		if err := s.blobStore.PutBlob(variant.Key, bytes.NewReader(data), variant.Size, contentType); err != nil {
+			return err
+		}
+		variants = append(variants, variant)
+	}
+
+	if err := s.mediaStore.SaveMediaVariants(media.ID, variants); err != nil {
+		return err
+	}
+
+	if err := s.blobStore.DeleteBlob(media.Key); err != nil {
+		log.Printf("[media] error: deleting upload %s: %s", media.Key, err)
+	}
+	return nil
+}
+
+var errUndecodableMedia = errors.New("the image can't be decoded")
+
+func (s *Server) decodeUpload(media *model.Media) (image.Image, error) {
+	blob, err := s.blobStore.GetBlob(media.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errUndecodableMedia, err)
+	}
+	if media.ContentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, nil
+}
+
+// fit scales the image down to fit in a size by size box, keeping its aspect
+// ratio. A size of 0 keeps the image as it is.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if size == 0 || (w <= size && h <= size) {
+		return img
+	}
+
+	if w >= h {
+		w, h = size, h*size/w
+	} else {
+		w, h = w*size/h, size
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// encodeVariant encodes opaque images as JPEG and the others as PNG, to keep
+// their transparency. Neither carries over any metadata of the upload.
+func encodeVariant(img image.Image) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
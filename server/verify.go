@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+	"github.com/orhanfatih/blog-api/model"
+	"github.com/orhanfatih/blog-api/repository"
+)
+
+const (
+	verificationTokenTTL = 24 * time.Hour
+	// verificationResendInterval is how long users wait before they can get
+	// another verification email
+	verificationResendInterval = 5 * time.Minute
+
+	purposeVerifyEmail = "verify-email"
+	purposeChangeEmail = "change-email"
+)
+
+var errInvalidLink = errors.New("The link is invalid or has expired")
+
+// createEmailToken signs a token for a link emailed to the user, carrying
+// the given claims. It's bound to the current email address of the user, so
+// it stops working when the address changes, and to a purpose, so it can't be
+// used for anything else.
+func (s *Server) createEmailToken(user *model.User, purpose string, claims jwt.MapClaims, expiration time.Duration) (string, error) {
+	all := jwt.MapClaims{
+		"sub":     user.ID,
+		"email":   user.Email,
+		"purpose": purpose,
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+
+	return s.keys.sign(all, emailTokenAudience, expiration)
+}
+
+// validateEmailToken checks a token made by createEmailToken for the purpose
+// and returns the user it was made for, along with its claims.
+func (s *Server) validateEmailToken(tokenString, purpose string) (*model.User, jwt.MapClaims, error) {
+	claims, err := s.keys.validate(tokenString, emailTokenAudience)
+	if err != nil || claims["purpose"] != purpose {
+		return nil, nil, errInvalidLink
+	}
+
+	userID, err := strconv.Atoi(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return nil, nil, errInvalidLink
+	}
+	user, err := s.userStore.FindUser(userID)
+	if err != nil || claims["email"] != user.Email {
+		return nil, nil, errInvalidLink
+	}
+	return user, claims, nil
+}
+
+// appURL returns the absolute URL of an API path, based on APP_URL. The Host
+// header of the request is never used, as anyone can set it to send users
+// links to their own site.
+func (s *Server) appURL(path string, query url.Values) string {
+	if len(query) == 0 {
+		return s.baseURL + path
+	}
+	return s.baseURL + path + "?" + query.Encode()
+}
+
+// sendVerificationEmail emails the user a link that verifies their email
+// address.
+func (s *Server) sendVerificationEmail(user *model.User) error {
+	token, err := s.createEmailToken(user, purposeVerifyEmail, nil, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.appURL("/v1/auth/verify", url.Values{"token": {token}})
+	return s.mailer.Send(&repository.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + user.Name + ",\n\n" +
+			"Open this link to verify your email address:\n\n" +
+			link + "\n\n" +
+			"The link expires in 24 hours. If you didn't sign up, you can ignore this email.\n",
+	})
+}
+
+// handleVerifyEmail verifies the email address of the user a verification
+// link was sent to.
+func (s *Server) handleVerifyEmail(c echo.Context) error {
+	user, _, err := s.validateEmailToken(c.QueryParam("token"), purposeVerifyEmail)
+	if err != nil {
+		return RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if err := s.userStore.VerifyUser(int(user.ID)); err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}
+
+// handleResendVerification sends the logged in user a new verification link,
+// at most once every few minutes.
+func (s *Server) handleResendVerification(c echo.Context) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	if user.IsVerified() {
+		return RespondWithError(c, http.StatusBadRequest, "Your email address is already verified")
+	}
+
+	ok, err := s.userStore.ReserveVerificationEmail(int(user.ID), time.Now().Add(-verificationResendInterval))
+	if err != nil {
+		return RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+	if !ok {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(verificationResendInterval.Seconds())))
+		return RespondWithError(c, http.StatusTooManyRequests, "A verification email was sent recently, try again later")
+	}
+
+	if err := s.sendVerificationEmail(user); err != nil {
+		log.Printf("failed to send verification email to user %d: %s", user.ID, err)
+		return RespondWithError(c, http.StatusInternalServerError, "The verification email couldn't be sent")
+	}
+
+	return RespondWithJSON(c, http.StatusOK, "success")
+}